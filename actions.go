@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// every change the controller makes to the cluster goes through the functions below, so that
+// in dry run mode they are logged and counted instead of being made. Nothing changes in dry run
+// mode, so every resync would repeat the same actions, an action is only reported again once the
+// action or the desired object changes.
+
+var actionsTotal = metrics.newMetric("spark_ui_actions_total", "counter",
+	"Changes made to the cluster, or that would have been made in dry run mode.", "verb", "kind", "dry_run")
+
+func (c *Controller) countAction(verb, kind string) {
+	actionsTotal.inc(verb, kind, strconv.FormatBool(c.dryRun))
+}
+
+// reportDryRun tells whether a dry run action on an object is logged and counted, it is when the
+// object is first seen or when the action or desired object differ from the last reported one.
+func (c *Controller) reportDryRun(verb, kind, namespace, name string, desired interface{}) bool {
+	h := fnv.New64a()
+	h.Write([]byte(verb))
+	if desired != nil {
+		out, err := json.Marshal(desired)
+		if err != nil {
+			klog.Errorf("Marshal %s %s/%s failed: %s", kind, namespace, name, err.Error())
+		}
+		h.Write(out)
+	}
+	key := namespace + "/" + name + "/" + kind
+	c.dryRunLock.Lock()
+	seen, ok := c.dryRunActions[key]
+	c.dryRunActions[key] = h.Sum64()
+	c.dryRunLock.Unlock()
+	if ok && seen == h.Sum64() {
+		return false
+	}
+	c.countAction(verb, kind)
+	return true
+}
+
+// forgetDryRunActions forgets the dry run actions reported on the objects of a deleted driver
+// service.
+func (c *Controller) forgetDryRunActions(namespace, driverName string) {
+	uiServiceName := getSparkUIServiceName(driverName)
+	names := map[string]bool{driverName: true, uiServiceName: true,
+		c.getSparkUIIngressRouteName(uiServiceName): true, getSparkUINetworkPolicyName(driverName): true,
+		getSparkUIDNSEndpointName(driverName): true, getSparkUIEndpointName(driverName): true}
+	c.dryRunLock.Lock()
+	defer c.dryRunLock.Unlock()
+	for key := range c.dryRunActions {
+		parts := strings.SplitN(key, "/", 3)
+		if parts[0] == namespace && names[parts[1]] {
+			delete(c.dryRunActions, key)
+		}
+	}
+}
+
+// logDryRunCreate logs the object that would be created.
+func logDryRunCreate(kind string, obj interface{}, meta metav1.ObjectMeta) {
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		klog.Errorf("Marshal %s %s/%s failed: %s", kind, meta.Namespace, meta.Name, err.Error())
+	}
+	klog.Infof("[dry-run] would create %s %s/%s:\n%s", kind, meta.Namespace, meta.Name, out)
+}
+
+// logDryRunUpdate logs the difference between the current and desired object.
+func logDryRunUpdate(kind string, current, desired interface{}, meta metav1.ObjectMeta) {
+	klog.Infof("[dry-run] would update %s %s/%s:\n%s", kind, meta.Namespace, meta.Name,
+		diff.ObjectReflectDiff(current, desired))
+}
+
+func (c *Controller) createService(svc *corev1.Service) (*corev1.Service, error) {
+	if c.dryRun {
+		if c.reportDryRun("create", "service", svc.Namespace, svc.Name, svc) {
+			logDryRunCreate("service", svc, svc.ObjectMeta)
+		}
+		return svc, nil
+	}
+	c.countAction("create", "service")
+	return c.kubeclientset.CoreV1().Services(svc.Namespace).Create(svc)
+}
+
+func (c *Controller) updateService(current, desired *corev1.Service) (*corev1.Service, error) {
+	if c.dryRun {
+		if c.reportDryRun("update", "service", desired.Namespace, desired.Name, desired) {
+			logDryRunUpdate("service", current, desired, desired.ObjectMeta)
+		}
+		return desired, nil
+	}
+	c.countAction("update", "service")
+	return c.kubeclientset.CoreV1().Services(desired.Namespace).Update(desired)
+}
+
+func (c *Controller) deleteService(namespace, name string) error {
+	if c.dryRun {
+		if c.reportDryRun("delete", "service", namespace, name, nil) {
+			klog.Infof("[dry-run] would delete service %s/%s", namespace, name)
+		}
+		return nil
+	}
+	c.countAction("delete", "service")
+	return c.kubeclientset.CoreV1().Services(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (c *Controller) createNetworkPolicy(policy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	if c.dryRun {
+		if c.reportDryRun("create", "networkpolicy", policy.Namespace, policy.Name, policy) {
+			logDryRunCreate("networkpolicy", policy, policy.ObjectMeta)
+		}
+		return policy, nil
+	}
+	c.countAction("create", "networkpolicy")
+	return c.kubeclientset.NetworkingV1().NetworkPolicies(policy.Namespace).Create(policy)
+}
+
+func (c *Controller) updateNetworkPolicy(current, desired *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	if c.dryRun {
+		if c.reportDryRun("update", "networkpolicy", desired.Namespace, desired.Name, desired) {
+			logDryRunUpdate("networkpolicy", current, desired, desired.ObjectMeta)
+		}
+		return desired, nil
+	}
+	c.countAction("update", "networkpolicy")
+	return c.kubeclientset.NetworkingV1().NetworkPolicies(desired.Namespace).Update(desired)
+}
+
+func (c *Controller) deleteNetworkPolicy(namespace, name string) error {
+	if c.dryRun {
+		if c.reportDryRun("delete", "networkpolicy", namespace, name, nil) {
+			klog.Infof("[dry-run] would delete networkpolicy %s/%s", namespace, name)
+		}
+		return nil
+	}
+	c.countAction("delete", "networkpolicy")
+	return c.kubeclientset.NetworkingV1().NetworkPolicies(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (c *Controller) createDNSEndpoint(endpoint *DNSEndpoint) error {
+	if c.dryRun {
+		if c.reportDryRun("create", "dnsendpoint", endpoint.Namespace, endpoint.Name, endpoint) {
+			logDryRunCreate("dnsendpoint", endpoint, endpoint.ObjectMeta)
+		}
+		return nil
+	}
+	c.countAction("create", "dnsendpoint")
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(endpoint)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicclientset.Resource(dnsEndpointResource).Namespace(endpoint.Namespace).Create(
+		&unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+	return err
+}
+
+func (c *Controller) updateDNSEndpoint(current, desired *DNSEndpoint) error {
+	if c.dryRun {
+		if c.reportDryRun("update", "dnsendpoint", desired.Namespace, desired.Name, desired) {
+			logDryRunUpdate("dnsendpoint", current, desired, desired.ObjectMeta)
+		}
+		return nil
+	}
+	c.countAction("update", "dnsendpoint")
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicclientset.Resource(dnsEndpointResource).Namespace(desired.Namespace).Update(
+		&unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Controller) deleteDNSEndpoint(namespace, name string) error {
+	if c.dryRun {
+		if c.reportDryRun("delete", "dnsendpoint", namespace, name, nil) {
+			klog.Infof("[dry-run] would delete dnsendpoint %s/%s", namespace, name)
+		}
+		return nil
+	}
+	c.countAction("delete", "dnsendpoint")
+	return c.dynamicclientset.Resource(dnsEndpointResource).Namespace(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (c *Controller) createIngressRoute(route *contourv1.IngressRoute) (*contourv1.IngressRoute, error) {
+	if c.dryRun {
+		if c.reportDryRun("create", "ingressroute", route.Namespace, route.Name, route) {
+			logDryRunCreate("ingressroute", route, route.ObjectMeta)
+		}
+		return route, nil
+	}
+	c.countAction("create", "ingressroute")
+	return c.contourclientset.ContourV1beta1().IngressRoutes(route.Namespace).Create(route)
+}
+
+func (c *Controller) updateIngressRoute(current, desired *contourv1.IngressRoute) (*contourv1.IngressRoute, error) {
+	if c.dryRun {
+		if c.reportDryRun("update", "ingressroute", desired.Namespace, desired.Name, desired) {
+			logDryRunUpdate("ingressroute", current, desired, desired.ObjectMeta)
+		}
+		return desired, nil
+	}
+	c.countAction("update", "ingressroute")
+	return c.contourclientset.ContourV1beta1().IngressRoutes(desired.Namespace).Update(desired)
+}
+
+func (c *Controller) deleteIngressRoute(namespace, name string) error {
+	if c.dryRun {
+		if c.reportDryRun("delete", "ingressroute", namespace, name, nil) {
+			klog.Infof("[dry-run] would delete ingressroute %s/%s", namespace, name)
+		}
+		return nil
+	}
+	c.countAction("delete", "ingressroute")
+	return c.contourclientset.ContourV1beta1().IngressRoutes(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (c *Controller) updatePolicyStatus(resource schema.GroupVersionResource, namespace, name string,
+	status SparkUIExposurePolicyStatus) error {
+	if c.dryRun {
+		if c.reportDryRun("update", resource.Resource+"/status", namespace, name, status) {
+			klog.Infof("[dry-run] would update status of %s %s/%s: %+v", resource.Resource, namespace, name, status)
+		}
+		return nil
+	}
+	c.countAction("update", resource.Resource+"/status")
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	client := c.dynamicclientset.Resource(resource).Namespace(namespace)
+	u, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	u.Object["status"] = obj
+	_, err = client.UpdateStatus(u, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Controller) createSparkUIEndpoint(endpoint *SparkUIEndpoint) (*SparkUIEndpoint, error) {
+	if c.dryRun {
+		if c.reportDryRun("create", "sparkuiendpoint", endpoint.Namespace, endpoint.Name, endpoint) {
+			logDryRunCreate("sparkuiendpoint", endpoint, endpoint.ObjectMeta)
+		}
+		return endpoint, nil
+	}
+	c.countAction("create", "sparkuiendpoint")
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	created, err := c.dynamicclientset.Resource(sparkUIEndpointResource).Namespace(endpoint.Namespace).Create(
+		&unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return endpointFromObject(created)
+}
+
+func (c *Controller) deleteSparkUIEndpoint(namespace, name string) error {
+	if c.dryRun {
+		if c.reportDryRun("delete", "sparkuiendpoint", namespace, name, nil) {
+			klog.Infof("[dry-run] would delete sparkuiendpoint %s/%s", namespace, name)
+		}
+		return nil
+	}
+	c.countAction("delete", "sparkuiendpoint")
+	return c.dynamicclientset.Resource(sparkUIEndpointResource).Namespace(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+func (c *Controller) updateSparkUIEndpointStatus(current, desired *SparkUIEndpoint) error {
+	if c.dryRun {
+		if c.reportDryRun("update", "sparkuiendpoint/status", desired.Namespace, desired.Name, desired.Status) {
+			logDryRunUpdate("sparkuiendpoint", current.Status, desired.Status, desired.ObjectMeta)
+		}
+		return nil
+	}
+	c.countAction("update", "sparkuiendpoint/status")
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicclientset.Resource(sparkUIEndpointResource).Namespace(desired.Namespace).UpdateStatus(
+		&unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	apiPrefix      = "/api/v1/"
+	uisPath        = apiPrefix + "uis"
+	watchUIsPath   = apiPrefix + "watch/uis"
+	eventAdded     = "ADDED"
+	eventDeleted   = "DELETED"
+	watchKeepAlive = 30 * time.Second
+	// watchBuffer is how many events a slow watcher may fall behind before events are dropped for it.
+	watchBuffer = 64
+)
+
+// SparkUIEvent is streamed to the watchers of the api when the controller reconciles a driver.
+type SparkUIEvent struct {
+	Type string `json:"type"`
+	// Namespace and Name of the driver service, UI is not set for deleted drivers.
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	UI        *SparkUI `json:"ui,omitempty"`
+}
+
+// sparkUIEventBroadcaster fans out spark ui events to the api watchers.
+type sparkUIEventBroadcaster struct {
+	lock     sync.Mutex
+	watchers map[chan SparkUIEvent]struct{}
+	// exposed are the drivers, by namespace/name, whose spark ui is known to be exposed, so each
+	// one is added and deleted once.
+	exposed map[string]bool
+}
+
+func newSparkUIEventBroadcaster() *sparkUIEventBroadcaster {
+	return &sparkUIEventBroadcaster{
+		watchers: map[chan SparkUIEvent]struct{}{},
+		exposed:  map[string]bool{},
+	}
+}
+
+// setExposed records whether the spark ui of a driver is exposed, it reports whether that changed.
+func (b *sparkUIEventBroadcaster) setExposed(namespace, name string, exposed bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	key := namespace + "/" + name
+	if b.exposed[key] == exposed {
+		return false
+	}
+	if exposed {
+		b.exposed[key] = true
+	} else {
+		delete(b.exposed, key)
+	}
+	return true
+}
+
+// isExposed reports whether the spark ui of a driver is exposed.
+func (b *sparkUIEventBroadcaster) isExposed(namespace, name string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.exposed[namespace+"/"+name]
+}
+
+func (b *sparkUIEventBroadcaster) watch() chan SparkUIEvent {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ch := make(chan SparkUIEvent, watchBuffer)
+	b.watchers[ch] = struct{}{}
+	return ch
+}
+
+func (b *sparkUIEventBroadcaster) stop(ch chan SparkUIEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.watchers, ch)
+}
+
+// publish never blocks the controller, a watcher that is not keeping up misses events.
+func (b *sparkUIEventBroadcaster) publish(event SparkUIEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.watchers {
+		select {
+		case ch <- event:
+		default:
+			klog.Warningf("Spark ui watcher is too slow, dropping %s event for %s/%s", event.Type,
+				event.Namespace, event.Name)
+		}
+	}
+}
+
+// API is the read only json api over the spark uis managed by the controller:
+//
+//	GET /api/v1/uis?namespace=&selector=   list spark uis
+//	GET /api/v1/uis/{namespace}/{name}     get the spark ui of a driver service
+//	GET /api/v1/watch/uis                  server-sent events of the exposed drivers, then as drivers
+//	                                       are added and removed
+type API struct {
+	controller *Controller
+}
+
+// NewAPI returns a new spark ui json api.
+func NewAPI(controller *Controller) *API {
+	return &API{controller: controller}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	switch {
+	case r.URL.Path == uisPath:
+		a.listUIs(w, r)
+	case strings.HasPrefix(r.URL.Path, uisPath+"/"):
+		a.getUI(w, r, strings.TrimPrefix(r.URL.Path, uisPath+"/"))
+	case r.URL.Path == watchUIsPath:
+		a.watchUIs(w, r)
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *API) listUIs(w http.ResponseWriter, r *http.Request) {
+	selector, err := labels.Parse(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid label selector: "+err.Error())
+		return
+	}
+	uis, err := a.controller.listSparkUIs(r.URL.Query().Get("namespace"), selector)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if uis == nil {
+		uis = []SparkUI{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Items []SparkUI `json:"items"`
+	}{Items: uis})
+}
+
+func (a *API) getUI(w http.ResponseWriter, r *http.Request, key string) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeJSONError(w, http.StatusNotFound, "expected "+uisPath+"/{namespace}/{name}")
+		return
+	}
+	driver, err := a.controller.servicesLister.Services(parts[0]).Get(parts[1])
+	if err != nil && !errors.IsNotFound(err) {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err != nil || !a.controller.isSparkDriverService(driver) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("spark driver service %s not found", key))
+		return
+	}
+	ui, err := a.controller.newSparkUI(driver)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ui)
+}
+
+func (a *API) watchUIs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	// the watcher is registered before listing, so no change is missed between the two.
+	events := a.controller.events.watch()
+	defer a.controller.events.stop(events)
+	uis, err := a.controller.listSparkUIs("", labels.Everything())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// like a kubernetes watch, the stream starts with the spark uis exposed so far.
+	for i := range uis {
+		if a.controller.events.isExposed(uis[i].Namespace, uis[i].DriverService) {
+			writeSparkUIEvent(w, SparkUIEvent{Type: eventAdded, Namespace: uis[i].Namespace,
+				Name: uis[i].DriverService, UI: &uis[i]})
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			writeSparkUIEvent(w, event)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSparkUIEvent writes an event of the watch stream.
+func writeSparkUIEvent(w http.ResponseWriter, event SparkUIEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		klog.Errorf("Marshal spark ui event failed: %s", err.Error())
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.Errorf("Write json response failed: %s", err.Error())
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: message})
+}
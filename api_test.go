@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSparkUIEventBroadcaster(t *testing.T) {
+	b := newSparkUIEventBroadcaster()
+	ch := b.watch()
+
+	b.publish(SparkUIEvent{Type: eventAdded, Namespace: "default", Name: "test-driver-svc"})
+	event := <-ch
+	if event.Type != eventAdded || event.Name != "test-driver-svc" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// a watcher that is not reading must not block the controller.
+	for i := 0; i < watchBuffer+1; i++ {
+		b.publish(SparkUIEvent{Type: eventDeleted, Namespace: "default", Name: "test-driver-svc"})
+	}
+	if len(ch) != watchBuffer {
+		t.Errorf("expected %d buffered events, got %d", watchBuffer, len(ch))
+	}
+
+	b.stop(ch)
+	b.publish(SparkUIEvent{Type: eventAdded, Namespace: "default", Name: "test-driver-svc"})
+	if len(ch) != watchBuffer {
+		t.Errorf("stopped watcher received an event")
+	}
+}
+
+// newAPIFixture returns the api over a-driver-svc in the default namespace, labelled team=data,
+// and b-driver-svc in the other namespace.
+func newAPIFixture(t *testing.T) (*API, *Controller) {
+	f := newFixture(t)
+	a := newSparkDriverService("a-driver-svc")
+	a.Labels = map[string]string{"team": "data"}
+	b := newSparkDriverService("b-driver-svc")
+	b.Namespace = "other"
+	f.svcsLister = append(f.svcsLister, a, b)
+	f.addReadyDriverPod(a)
+	c, _, _ := f.newController()
+	return NewAPI(c), c
+}
+
+func TestAPIListUIs(t *testing.T) {
+	api, _ := newAPIFixture(t)
+	list := func(url string) (int, []SparkUI) {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var result struct {
+			Items []SparkUI `json:"items"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result.Items
+	}
+	names := func(uis []SparkUI) []string {
+		var names []string
+		for _, ui := range uis {
+			names = append(names, ui.Namespace+"/"+ui.DriverService)
+		}
+		return names
+	}
+
+	tests := []struct {
+		url   string
+		code  int
+		names []string
+	}{
+		{url: "/api/v1/uis", code: http.StatusOK, names: []string{"default/a-driver-svc", "other/b-driver-svc"}},
+		{url: "/api/v1/uis?namespace=other", code: http.StatusOK, names: []string{"other/b-driver-svc"}},
+		{url: "/api/v1/uis?selector=team%3Ddata", code: http.StatusOK, names: []string{"default/a-driver-svc"}},
+		{url: "/api/v1/uis?namespace=other&selector=team%3Ddata", code: http.StatusOK},
+		{url: "/api/v1/uis?selector=team+in+%28", code: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		code, uis := list(test.url)
+		if code != test.code || strings.Join(names(uis), ",") != strings.Join(test.names, ",") {
+			t.Errorf("%s: expected %d %v, got %d %v", test.url, test.code, test.names, code, names(uis))
+		}
+	}
+}
+
+func TestAPIGetUI(t *testing.T) {
+	api, _ := newAPIFixture(t)
+	get := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	w := get(http.MethodGet, "/api/v1/uis/default/a-driver-svc")
+	var ui SparkUI
+	if err := json.Unmarshal(w.Body.Bytes(), &ui); err != nil || w.Code != http.StatusOK ||
+		ui.DriverService != "a-driver-svc" || ui.AppID != "spark-test" {
+		t.Errorf("expected the spark ui of a-driver-svc, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, url := range []string{"/api/v1/uis/default/missing-driver-svc", "/api/v1/uis/default",
+		"/api/v1/uis/default/a-driver-svc/extra", "/api/v1/other"} {
+		w := get(http.MethodGet, url)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("%s: expected a 404 json error, got %d %s", url, w.Code, w.Body.String())
+		}
+	}
+	if code := get(http.MethodPost, "/api/v1/uis").Code; code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for a POST, got %d", code)
+	}
+}
+
+func TestAPIWatchUIs(t *testing.T) {
+	api, c := newAPIFixture(t)
+	c.events.setExposed(metav1.NamespaceDefault, "a-driver-svc", true)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/watch/uis")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	events := make(chan SparkUIEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				var event SparkUIEvent
+				json.Unmarshal([]byte(data), &event)
+				events <- event
+			}
+		}
+	}()
+	next := func() SparkUIEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return SparkUIEvent{}
+		}
+	}
+
+	// the exposed spark ui is sent first, b-driver-svc is not exposed.
+	event := next()
+	if event.Type != eventAdded || event.Name != "a-driver-svc" || event.UI == nil || event.UI.AppID != "spark-test" {
+		t.Errorf("expected the initial state, got %+v", event)
+	}
+	c.events.publish(SparkUIEvent{Type: eventDeleted, Namespace: metav1.NamespaceDefault, Name: "a-driver-svc"})
+	event = next()
+	if event.Type != eventDeleted || event.Name != "a-driver-svc" || event.UI != nil {
+		t.Errorf("expected the update, got %+v", event)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog"
+)
+
+// deleteSparkUIOfDriver deletes the spark ui service, network policy and ingress route of a
+// driver service that no longer exists, or that the detection rules no longer match. Garbage
+// collection handles the objects a deleted driver owns, this covers drivers that had no owner
+// at all and drivers that are kept. Only the objects managed for the driver are deleted, the
+// ones created by older versions are left alone until they are adopted. Routes redirecting to
+// the history server are kept until they expire.
+func (c *Controller) deleteSparkUIOfDriver(namespace, name string) error {
+	c.forgetUnmanagedObjects(namespace, name)
+	uiServiceName := getSparkUIServiceName(name)
+	uiService, err := c.servicesLister.Services(namespace).Get(uiServiceName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && isManagedBy(uiService.ObjectMeta, name) {
+		klog.Infof("spark ui of %s/%s is no longer published, deleting spark ui service %s", namespace, name,
+			uiServiceName)
+		err = c.deleteService(namespace, uiServiceName)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	policyName := getSparkUINetworkPolicyName(name)
+	policy, err := c.networkPoliciesLister.NetworkPolicies(namespace).Get(policyName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && isManagedBy(policy.ObjectMeta, name) {
+		klog.Infof("spark ui of %s/%s is no longer published, deleting network policy %s", namespace, name,
+			policyName)
+		err = c.deleteNetworkPolicy(namespace, policyName)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	ingressName := c.getSparkUIIngressRouteName(uiServiceName)
+	route, err := c.ingressRoutesLister.IngressRoutes(namespace).Get(ingressName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if route.Annotations[historyAppIDAnnotation] != "" || !isManagedBy(route.ObjectMeta, name) {
+		return nil
+	}
+	klog.Infof("spark ui of %s/%s is no longer published, deleting ingress route %s", namespace, name,
+		ingressName)
+	err = c.deleteIngressRoute(namespace, ingressName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
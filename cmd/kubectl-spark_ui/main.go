@@ -0,0 +1,145 @@
+// kubectl-spark_ui opens the spark ui of an application in a browser, it is a kubectl plugin:
+//
+//	kubectl spark-ui [-n namespace] <app>|sparkapplication/<name>|pod/<driver pod>
+//
+// The url is the one published by spark-ui-controller-envoy, the status of the SparkUIEndpoint of
+// the driver or the fqdn of its ingress route. When there is none, or it can not be reached from
+// here, the driver's ui port is forwarded to localhost instead.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("kubectl spark-ui", flag.ContinueOnError)
+	kubeconfig := fs.String("kubeconfig", "", "path to the kubeconfig, the kubectl default when empty.")
+	kubecontext := fs.String("context", "", "kubeconfig context to use.")
+	namespace := fs.String("n", "", "namespace of the application, the one of the context when empty.")
+	portForward := fs.Bool("port-forward", false, "always forward the driver's ui port instead of using the url.")
+	localPort := fs.Int("local-port", 0, "local port of the port forward, a free one when 0.")
+	noBrowser := fs.Bool("no-browser", false, "print the url instead of opening it.")
+	timeout := fs.Duration("timeout", 3*time.Second, "how long to wait for the published url to answer.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kubectl spark-ui [flags] <app>|sparkapplication/<name>|pod/<driver pod>")
+		fs.PrintDefaults()
+	}
+	// flags may follow the target, as with kubectl.
+	var targets []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		targets = append(targets, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(targets) != 1 {
+		fs.Usage()
+		return 2
+	}
+	target, err := parseTarget(targets[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: *kubecontext})
+	if *namespace == "" {
+		if *namespace, _, err = clientConfig.Namespace(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	r := &resolver{kube: kubeClient, dynamic: dynamicClient, namespace: *namespace}
+
+	driver, err := r.driverService(target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*portForward {
+		url, err := r.publishedURL(driver)
+		switch {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		case url == "":
+			fmt.Fprintf(os.Stderr, "%s/%s has no published url, forwarding its ui port\n", driver.Namespace,
+				driver.Name)
+		case !reachable(url, *timeout):
+			fmt.Fprintf(os.Stderr, "%s can not be reached from here, forwarding the ui port\n", url)
+		default:
+			return show(url, *noBrowser)
+		}
+	}
+
+	pod, err := r.driverPod(driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	stopCh := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopCh)
+	}()
+	err = forwardPort(restConfig, kubeClient, pod, *localPort, stopCh, func(url string) {
+		fmt.Fprintf(os.Stderr, "Forwarding %s to the ui of pod %s/%s, press Ctrl-C to stop\n", url,
+			pod.Namespace, pod.Name)
+		show(url, *noBrowser)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// show opens url in a browser, or prints it.
+func show(url string, noBrowser bool) int {
+	if noBrowser {
+		fmt.Println(url)
+		return 0
+	}
+	if err := openBrowser(url); err != nil {
+		fmt.Fprintf(os.Stderr, "open a browser failed: %s\n", err.Error())
+		fmt.Println(url)
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// forwardPort forwards localPort, a free one when 0, to the ui port of a driver pod until stopCh
+// is closed. ready is called with the local url once the port is listening.
+func forwardPort(config *rest.Config, kubeClient kubernetes.Interface, pod *corev1.Pod, localPort int,
+	stopCh chan struct{}, ready func(url string)) error {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return err
+	}
+	req := kubeClient.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).
+		Name(pod.Name).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+
+	readyCh := make(chan struct{})
+	ports := []string{fmt.Sprintf("%d:%d", localPort, sparkUIPort)}
+	fw, err := portforward.New(dialer, ports, stopCh, readyCh, ioutil.Discard, os.Stderr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-readyCh:
+		case <-stopCh:
+			return
+		}
+		forwarded, err := fw.GetPorts()
+		if err != nil || len(forwarded) == 0 {
+			fmt.Fprintf(os.Stderr, "get forwarded port failed: %v\n", err)
+			return
+		}
+		ready(fmt.Sprintf("http://localhost:%d/", forwarded[0].Local))
+	}()
+	return fw.ForwardPorts()
+}
+
+// openBrowser opens url with the default browser of the desktop.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
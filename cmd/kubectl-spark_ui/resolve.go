@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// the names below mirror the controller, the plugin is built on its own and does not import it.
+const (
+	driverServiceSuffix = "-driver-svc"
+	driverServiceLabel  = "spark-ui.ushareit.com/driver-service"
+	sparkUIPort         = 4040
+)
+
+var (
+	sparkUIEndpointResource = schema.GroupVersionResource{
+		Group: "spark-ui.ushareit.com", Version: "v1alpha1", Resource: "sparkuiendpoints",
+	}
+	ingressRouteResource = schema.GroupVersionResource{
+		Group: "contour.heptio.com", Version: "v1beta1", Resource: "ingressroutes",
+	}
+	// sparkApplicationResource is the application of the spark operator.
+	sparkApplicationResource = schema.GroupVersionResource{
+		Group: "sparkoperator.k8s.io", Version: "v1beta2", Resource: "sparkapplications",
+	}
+)
+
+const (
+	targetApp              = "app"
+	targetSparkApplication = "sparkapplication"
+	targetPod              = "pod"
+)
+
+// target is what the user asked to open.
+type target struct {
+	kind string
+	name string
+}
+
+// parseTarget parses <app>, sparkapplication/<name> or pod/<name>, with the kubectl short names.
+func parseTarget(arg string) (target, error) {
+	parts := strings.SplitN(arg, "/", 2)
+	if len(parts) == 1 {
+		if arg == "" {
+			return target{}, fmt.Errorf("empty application name")
+		}
+		return target{kind: targetApp, name: strings.TrimSuffix(arg, driverServiceSuffix)}, nil
+	}
+	if parts[1] == "" {
+		return target{}, fmt.Errorf("invalid target %q, the name is missing", arg)
+	}
+	switch strings.ToLower(parts[0]) {
+	case "sparkapplication", "sparkapplications", "sparkapp":
+		return target{kind: targetSparkApplication, name: parts[1]}, nil
+	case "pod", "pods", "po":
+		return target{kind: targetPod, name: parts[1]}, nil
+	}
+	return target{}, fmt.Errorf("invalid target %q, expected <app>, sparkapplication/<name> or pod/<name>", arg)
+}
+
+// resolver finds the driver of a target and its spark ui in a namespace.
+type resolver struct {
+	kube      kubernetes.Interface
+	dynamic   dynamic.Interface
+	namespace string
+}
+
+// driverService returns the driver service of a target.
+func (r *resolver) driverService(t target) (*corev1.Service, error) {
+	switch t.kind {
+	case targetPod:
+		return r.driverServiceOfPod(t.name)
+	case targetSparkApplication:
+		return r.driverServiceOfSparkApplication(t.name)
+	}
+	svc, err := r.kube.CoreV1().Services(r.namespace).Get(t.name+driverServiceSuffix, metav1.GetOptions{})
+	if err == nil {
+		return svc, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	// spark-submit names the driver service after the pod name prefix, with the spark operator
+	// it differs from the application name.
+	svc, appErr := r.driverServiceOfSparkApplication(t.name)
+	if appErr != nil {
+		return nil, fmt.Errorf("no driver service %s%s in namespace %s, and %s", t.name, driverServiceSuffix,
+			r.namespace, appErr.Error())
+	}
+	return svc, nil
+}
+
+// driverServiceOfSparkApplication follows the driver pod of a spark operator application.
+func (r *resolver) driverServiceOfSparkApplication(name string) (*corev1.Service, error) {
+	app, err := r.dynamic.Resource(sparkApplicationResource).Namespace(r.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get sparkapplication %s/%s: %s", r.namespace, name, err.Error())
+	}
+	pod, _, err := unstructured.NestedString(app.Object, "status", "driverInfo", "podName")
+	if err != nil || pod == "" {
+		return nil, fmt.Errorf("sparkapplication %s/%s has no driver pod yet", r.namespace, name)
+	}
+	return r.driverServiceOfPod(pod)
+}
+
+// driverServiceOfPod returns the driver service selecting a driver pod.
+func (r *resolver) driverServiceOfPod(name string) (*corev1.Service, error) {
+	pod, err := r.kube.CoreV1().Pods(r.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	services, err := r.kube.CoreV1().Services(r.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if strings.HasSuffix(svc.Name, driverServiceSuffix) && len(svc.Spec.Selector) > 0 &&
+			labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			return svc, nil
+		}
+	}
+	return nil, fmt.Errorf("no driver service selects pod %s/%s, is it a spark driver?", r.namespace, name)
+}
+
+// publishedURL returns the url the controller published for a driver, empty when there is none:
+// the url of its SparkUIEndpoint, or else the fqdn of its ingress route.
+func (r *resolver) publishedURL(driver *corev1.Service) (string, error) {
+	endpoint, err := r.dynamic.Resource(sparkUIEndpointResource).Namespace(driver.Namespace).Get(
+		strings.TrimSuffix(driver.Name, driverServiceSuffix), metav1.GetOptions{})
+	if err == nil {
+		if url, _, _ := unstructured.NestedString(endpoint.Object, "status", "url"); url != "" {
+			return url, nil
+		}
+	} else if !errors.IsNotFound(err) {
+		return "", err
+	}
+	// older controllers do not create endpoints, their routes are labeled with the driver service.
+	routes, err := r.dynamic.Resource(ingressRouteResource).Namespace(driver.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{driverServiceLabel: driver.Name}).String(),
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, route := range routes.Items {
+		if fqdn, _, _ := unstructured.NestedString(route.Object, "spec", "virtualhost", "fqdn"); fqdn != "" {
+			return "http://" + fqdn + "/", nil
+		}
+	}
+	return "", nil
+}
+
+// driverPod returns a running pod selected by a driver service.
+func (r *resolver) driverPod(driver *corev1.Service) (*corev1.Pod, error) {
+	pods, err := r.kube.CoreV1().Pods(driver.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(driver.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no running driver pod for %s/%s, the application has finished or not started",
+		driver.Namespace, driver.Name)
+}
+
+// reachable reports whether url answers, a server error means the route exists but the ui behind
+// it is not reachable either.
+func reachable(url string, timeout time.Duration) bool {
+	client := &http.Client{
+		Timeout: timeout,
+		// the history redirects are followed by the browser.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
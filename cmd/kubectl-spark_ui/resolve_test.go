@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseTarget(t *testing.T) {
+	for _, tc := range []struct {
+		arg      string
+		expected target
+		invalid  bool
+	}{
+		{arg: "etl", expected: target{kind: targetApp, name: "etl"}},
+		{arg: "etl-driver-svc", expected: target{kind: targetApp, name: "etl"}},
+		{arg: "sparkapp/etl", expected: target{kind: targetSparkApplication, name: "etl"}},
+		{arg: "po/etl-driver", expected: target{kind: targetPod, name: "etl-driver"}},
+		{arg: "pod/", invalid: true},
+		{arg: "deployment/etl", invalid: true},
+		{arg: "", invalid: true},
+	} {
+		got, err := parseTarget(tc.arg)
+		if (err != nil) != tc.invalid {
+			t.Errorf("%q: expected invalid %v, got %v", tc.arg, tc.invalid, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %+v, got %+v", tc.arg, tc.expected, got)
+		}
+	}
+}
+
+func TestResolveDriverPod(t *testing.T) {
+	selector := map[string]string{"spark-app-selector": "spark-1234", "spark-role": "driver"}
+	driver := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl-1a2b-driver-svc", Namespace: "spark"},
+		Spec:       corev1.ServiceSpec{Selector: selector},
+	}
+	other := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-driver-svc", Namespace: "spark"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"spark-app-selector": "spark-5678"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl-1a2b-driver", Namespace: "spark", Labels: selector},
+	}
+	endpoint := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": sparkUIEndpointResource.GroupVersion().String(),
+		"kind":       "SparkUIEndpoint",
+		"metadata":   map[string]interface{}{"name": "etl-1a2b", "namespace": "spark"},
+		"status":     map[string]interface{}{"url": "http://etl-1a2b-driver-svc.spark-ui.example.com/"},
+	}}
+	r := &resolver{
+		kube:      k8sfake.NewSimpleClientset(driver, other, pod),
+		dynamic:   dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), endpoint),
+		namespace: "spark",
+	}
+
+	svc, err := r.driverService(target{kind: targetPod, name: pod.Name})
+	if err != nil {
+		t.Fatalf("resolve pod: %v", err)
+	}
+	if svc.Name != driver.Name {
+		t.Errorf("expected driver service %s, got %s", driver.Name, svc.Name)
+	}
+	url, err := r.publishedURL(svc)
+	if err != nil {
+		t.Fatalf("published url: %v", err)
+	}
+	if url != "http://etl-1a2b-driver-svc.spark-ui.example.com/" {
+		t.Errorf("unexpected url %q", url)
+	}
+	if _, err := r.driverService(target{kind: targetApp, name: "missing"}); err == nil {
+		t.Errorf("expected an error for a missing application")
+	}
+}
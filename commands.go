@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// startController builds the controller from the flags and waits for its caches, for the one
+// shot commands that read or reconcile the cluster without running the workers.
+func startController(stopCh <-chan struct{}) (*Controller, error) {
+	controller, informerFactory, contourInformerFactory, dynamicInformerFactory := buildController()
+	informerFactory.Start(stopCh)
+	contourInformerFactory.Start(stopCh)
+	dynamicInformerFactory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, controller.HasSynced); !ok {
+		return nil, fmt.Errorf("Error syncing cache")
+	}
+	return controller, nil
+}
+
+// runList implements the list command, it prints every spark driver and where its ui is exposed:
+//
+//	spark-ui-controller-envoy [flags] list [-n namespace] [-l selector] [-o table|json|yaml]
+func runList(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	namespace := fs.String("n", "", "namespace to list, all namespaces when empty.")
+	selector := fs.String("l", "", "label selector matched against the driver service and pod labels.")
+	output := fs.String("o", "table", "output format, table, json or yaml.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	sel, err := labels.Parse(*selector)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid selector: %s\n", err.Error())
+		return 2
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	controller, err := startController(stopCh)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	uis, err := controller.listSparkUIs(*namespace, sel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if uis == nil {
+		uis = []SparkUI{}
+	}
+	if err := printSparkUIs(os.Stdout, uis, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printSparkUIs writes uis to w in the given output format.
+func printSparkUIs(w io.Writer, uis []SparkUI, output string) error {
+	switch output {
+	case "json":
+		out, err := json.MarshalIndent(uis, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	case "yaml":
+		out, err := yaml.Marshal(uis)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAMESPACE\tDRIVER\tPHASE\tUI SERVICE\tROUTE\tURL\tHEALTH\tAGE")
+		for _, ui := range uis {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", ui.Namespace, ui.DriverService,
+				orNone(ui.Phase), orNone(ui.UIService), orNone(ui.Route), ui.URL, ui.Health, ui.Age())
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q, expected table, json or yaml", output)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// runReconcile implements the reconcile command, it syncs driver services once and exits with
+// a non zero status if any of them failed:
+//
+//	spark-ui-controller-envoy [flags] reconcile <namespace>/<driver service>...
+//	spark-ui-controller-envoy [flags] reconcile -n <namespace>
+//	spark-ui-controller-envoy [flags] reconcile -A
+func runReconcile(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	namespace := fs.String("n", "", "reconcile every driver service of a namespace.")
+	all := fs.Bool("A", false, "reconcile every driver service of the cluster.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (fs.NArg() > 0 && (*namespace != "" || *all)) || (*namespace != "" && *all) {
+		fmt.Fprintln(os.Stderr, "pass either keys, -n or -A")
+		return 2
+	}
+	if fs.NArg() == 0 && *namespace == "" && !*all {
+		fmt.Fprintln(os.Stderr, "nothing to reconcile, pass <namespace>/<driver service> keys, -n or -A")
+		return 2
+	}
+	for _, key := range fs.Args() {
+		if _, _, err := cache.SplitMetaNamespaceKey(key); err != nil || !strings.Contains(key, "/") {
+			fmt.Fprintf(os.Stderr, "invalid key %q, expected <namespace>/<driver service>\n", key)
+			return 2
+		}
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	controller, err := startController(stopCh)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	keys := fs.Args()
+	if len(keys) == 0 {
+		services, err := controller.servicesLister.Services(*namespace).List(labels.Everything())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, svc := range services {
+			if controller.isSparkDriverService(svc) {
+				keys = append(keys, svc.Namespace+"/"+svc.Name)
+			}
+		}
+	}
+
+	return controller.reconcileKeys(keys, os.Stdout, os.Stderr)
+}
+
+// reconcileKeys syncs the driver services of keys, reporting each one to stdout or stderr, and
+// returns the exit status of the reconcile command. The keys syncHandler ignores are reported as
+// skipped rather than reconciled.
+func (c *Controller) reconcileKeys(keys []string, stdout, stderr io.Writer) int {
+	failed := 0
+	for _, key := range keys {
+		reason := c.ignoredKeyReason(key)
+		if err := c.syncHandler(key); err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", key, err.Error())
+			failed++
+			continue
+		}
+		if reason != "" {
+			fmt.Fprintf(stdout, "%s: skipped, %s\n", key, reason)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: reconciled\n", key)
+	}
+	if failed > 0 {
+		fmt.Fprintf(stderr, "%d of %d driver services failed\n", failed, len(keys))
+		return 1
+	}
+	return 0
+}
+
+// ignoredKeyReason tells why syncHandler does not publish the spark ui of a key, it is empty for
+// the driver services it reconciles and for the deleted ones it cleans up.
+func (c *Controller) ignoredKeyReason(key string) string {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return "invalid key"
+	}
+	if !strings.HasSuffix(name, driverServiceSuffix) {
+		return fmt.Sprintf("not ending with %s", driverServiceSuffix)
+	}
+	service, err := c.servicesLister.Services(namespace).Get(name)
+	if err != nil {
+		return ""
+	}
+	if !c.isSparkDriverService(service) {
+		return "not a spark driver service of the configured detection rules"
+	}
+	return ""
+}
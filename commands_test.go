@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestPrintSparkUIs(t *testing.T) {
+	uis := []SparkUI{
+		{Namespace: "spark", DriverService: "a-driver-svc", Phase: "Running", UIService: "a-ui-svc",
+			Route: "a-ui-svc-ingress", RouteStatus: "valid", URL: "http://a-driver-svc.test/"},
+		{Namespace: "spark", DriverService: "b-driver-svc", Phase: "Running", URL: "http://b-driver-svc.test/"},
+	}
+	for i := range uis {
+		uis[i].Health = uis[i].health()
+	}
+	if uis[0].Health != "ok" || uis[1].Health != "no ui service" {
+		t.Errorf("unexpected health %q and %q", uis[0].Health, uis[1].Health)
+	}
+
+	var buf bytes.Buffer
+	if err := printSparkUIs(&buf, uis, "table"); err != nil {
+		t.Fatalf("print table: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAMESPACE") || !strings.Contains(lines[2], "<none>") {
+		t.Errorf("unexpected table\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := printSparkUIs(&buf, uis, "json"); err != nil || !strings.Contains(buf.String(), `"health": "ok"`) {
+		t.Errorf("unexpected json %v\n%s", err, buf.String())
+	}
+	if err := printSparkUIs(&buf, uis, "xml"); err == nil {
+		t.Errorf("expected an error for an unknown output format")
+	}
+}
+
+func TestReconcileKeys(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("a-driver-svc")
+	failingService := newSparkDriverService("b-driver-svc")
+	failingService.Namespace = "other"
+	// the service does not select a driver pod.
+	undetectedService := newSparkDriverService("c-driver-svc")
+	undetectedService.Spec.Selector = nil
+	f.svcsLister = append(f.svcsLister, driverService, failingService, undetectedService)
+	f.addReadyDriverPod(driverService)
+	c, _, _ := f.newController()
+	f.kubeclient.PrependReactor("create", "services", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "other" {
+			return true, nil, fmt.Errorf("api server unavailable")
+		}
+		return false, nil, nil
+	})
+
+	tests := []struct {
+		keys   []string
+		status int
+		stdout []string
+		stderr []string
+	}{
+		{
+			keys:   []string{"default/a-driver-svc"},
+			stdout: []string{"default/a-driver-svc: reconciled"},
+		},
+		{
+			keys:   []string{"default/some-svc", "default/c-driver-svc"},
+			stdout: []string{"default/some-svc: skipped, not ending with -driver-svc", "default/c-driver-svc: skipped, not a spark driver service"},
+		},
+		{
+			keys:   []string{"other/b-driver-svc", "default/some-svc"},
+			status: 1,
+			stdout: []string{"default/some-svc: skipped"},
+			stderr: []string{"other/b-driver-svc: ", "api server unavailable", "1 of 2 driver services failed"},
+		},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if status := c.reconcileKeys(test.keys, &stdout, &stderr); status != test.status {
+			t.Errorf("%v: expected exit status %d, got %d", test.keys, test.status, status)
+		}
+		for _, s := range test.stdout {
+			if !strings.Contains(stdout.String(), s) {
+				t.Errorf("%v: expected %q in stdout\n%s", test.keys, s, stdout.String())
+			}
+		}
+		for _, s := range test.stderr {
+			if !strings.Contains(stderr.String(), s) {
+				t.Errorf("%v: expected %q in stderr\n%s", test.keys, s, stderr.String())
+			}
+		}
+		if len(test.stderr) == 0 && stderr.Len() > 0 {
+			t.Errorf("%v: unexpected stderr\n%s", test.keys, stderr.String())
+		}
+		if lines := strings.Count(stdout.String(), "\n"); lines != len(test.stdout) {
+			t.Errorf("%v: expected %d lines in stdout\n%s", test.keys, len(test.stdout), stdout.String())
+		}
+	}
+}
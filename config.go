@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// backendIngressRoute exposes the spark uis with contour IngressRoutes, the only backend so far.
+	backendIngressRoute = "ingressroute"
+
+	defaultHostTemplate = "{{.DriverService}}"
+
+	// configReloadInterval is how often the config file is checked for changes.
+	configReloadInterval = 10 * time.Second
+)
+
+// Config is the configuration of the controller. It is built from the flags, overridden by the
+// -config file when one is given, and can be swapped at runtime when that file changes.
+//
+//	detection:
+//	  selector:
+//	    spark-role: driver
+//	  namespaces: [spark]
+//	  excludeNamespaces: []
+//	backend: ingressroute
+//	hostname:
+//	  suffix: .spark-ui.example.com
+//	  template: "{{.AppName}}-{{.Namespace}}"
+//	timeouts:
+//	  request: 60s
+//	  perTry: 20s
+//	retries:
+//	  count: 2
+//	healthCheck:
+//	  path: /
+//	  interval: 10s
+//	security:
+//	  readOnly: true
+//	  sourceRanges: [10.0.0.0/8]
+//	networkPolicy:
+//	  enabled: true
+//	  from:
+//	  - namespaceSelector: {name: projectcontour}
+//	    podSelector: {app: envoy}
+//	dns:
+//	  mode: dnsendpoint
+//	  loadBalancer: projectcontour/envoy
+//	  ttl: 60
+type Config struct {
+	Detection DetectionConfig `json:"detection"`
+	// Backend exposing the spark uis, only ingressroute is supported.
+	Backend  string         `json:"backend"`
+	Hostname HostnameConfig `json:"hostname"`
+	Timeouts TimeoutsConfig `json:"timeouts"`
+	// Retries and HealthCheck are rendered by the backends that support them.
+	Retries     RetriesConfig     `json:"retries"`
+	HealthCheck HealthCheckConfig `json:"healthCheck"`
+	Security    SecurityConfig    `json:"security"`
+	// NetworkPolicy opens the ui port of the drivers in namespaces denying ingress by default.
+	NetworkPolicy NetworkPolicyConfig `json:"networkPolicy"`
+	// DNS publishes a record per spark ui where wildcard records are forbidden.
+	DNS DNSConfig `json:"dns"`
+
+	hostTemplate *template.Template
+}
+
+// DetectionConfig decides which services are spark driver services, on top of their name
+// ending with driverServiceSuffix.
+type DetectionConfig struct {
+	// Selector are labels the service selector must contain, spark-role=driver by default.
+	Selector map[string]string `json:"selector,omitempty"`
+	// Namespaces watched for drivers, all namespaces when empty.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ExcludeNamespaces are never watched for drivers.
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+}
+
+// HostnameConfig builds the host name of a spark ui, the template output followed by the suffix.
+// The template is a go text/template over Namespace, DriverService, AppName and AppID.
+type HostnameConfig struct {
+	Suffix   string `json:"suffix"`
+	Template string `json:"template"`
+}
+
+// TimeoutsConfig are the timeouts of the requests to the spark ui, go durations.
+type TimeoutsConfig struct {
+	// Request is the envoy and built-in proxy request timeout.
+	Request string `json:"request"`
+	// PerTry bounds each attempt of a retried request.
+	PerTry string `json:"perTry,omitempty"`
+	// Idle closes the requests without activity.
+	Idle string `json:"idle,omitempty"`
+}
+
+// RetriesConfig retries the requests failing on a transient driver error, e.g. a gc pause.
+type RetriesConfig struct {
+	Count int `json:"count"`
+	// On are the envoy retry conditions, the backend default when empty.
+	On []string `json:"on,omitempty"`
+}
+
+// HealthCheckConfig is the active http health check of the ui service, disabled when Path is
+// empty. Interval and Timeout are whole seconds, zero values are left to the backend defaults.
+type HealthCheckConfig struct {
+	Path               string `json:"path,omitempty"`
+	Interval           string `json:"interval,omitempty"`
+	Timeout            string `json:"timeout,omitempty"`
+	UnhealthyThreshold int    `json:"unhealthyThreshold,omitempty"`
+	HealthyThreshold   int    `json:"healthyThreshold,omitempty"`
+}
+
+// SecurityConfig are the default exposure options, annotations override them.
+type SecurityConfig struct {
+	ReadOnly     bool     `json:"readOnly"`
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+}
+
+// NetworkPolicyConfig creates a NetworkPolicy per driver letting From reach the ui port of the
+// driver pod. The driver pod is then isolated for ingress, its other ports must be allowed by
+// the policies of the namespace, as they are when it denies ingress by default.
+type NetworkPolicyConfig struct {
+	Enabled bool `json:"enabled"`
+	// From are the pods allowed to reach the ui port, the ingress controller and the controller
+	// itself for its proxy and prober.
+	From []NetworkPolicyPeerConfig `json:"from,omitempty"`
+}
+
+// NetworkPolicyPeerConfig selects pods by the labels of their namespace and their own labels, a
+// peer without namespaceSelector selects pods in the driver's namespace.
+type NetworkPolicyPeerConfig struct {
+	NamespaceSelector map[string]string `json:"namespaceSelector,omitempty"`
+	PodSelector       map[string]string `json:"podSelector,omitempty"`
+}
+
+// DNSConfig publishes the host name of every spark ui with external-dns, pointing it at the load
+// balancer address of the ingress controller. It is disabled when Mode is empty, the host names
+// are then expected to be covered by a wildcard record.
+type DNSConfig struct {
+	// Mode is annotations, the external-dns annotations on the ingress routes, or dnsendpoint, a
+	// DNSEndpoint object per driver for the external-dns crd source.
+	Mode string `json:"mode,omitempty"`
+	// LoadBalancer is the namespace/name of the envoy service whose load balancer address the
+	// records point at.
+	LoadBalancer string `json:"loadBalancer,omitempty"`
+	// TTL of the records in seconds, the external-dns default when zero.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// sparkUIHostData is what the hostname template is executed with.
+type sparkUIHostData struct {
+	Namespace     string
+	DriverService string
+	AppName       string
+	AppID         string
+}
+
+// configFromFlags returns the configuration given by the flags.
+func configFromFlags() *Config {
+	cfg := &Config{
+		Detection: DetectionConfig{Selector: map[string]string{"spark-role": "driver"}},
+		Backend:   backendIngressRoute,
+		Hostname:  HostnameConfig{Suffix: hostSuffix, Template: defaultHostTemplate},
+		Timeouts:  TimeoutsConfig{Request: requestTimeout},
+		Security:  SecurityConfig{ReadOnly: readOnly},
+	}
+	if sourceRanges != "" {
+		cfg.Security.SourceRanges = strings.Split(sourceRanges, ",")
+	}
+	return cfg
+}
+
+// loadConfig returns the configuration of the flags overridden by the file at path, if any,
+// and validated.
+func loadConfig(path string) (*Config, error) {
+	cfg := configFromFlags()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.merge(data); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// merge overrides the configuration with the fields set in a yaml document. A selector in the
+// document replaces the default one instead of being merged with it.
+func (cfg *Config) merge(data []byte) error {
+	selector := cfg.Detection.Selector
+	cfg.Detection.Selector = nil
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return err
+	}
+	if cfg.Detection.Selector == nil {
+		cfg.Detection.Selector = selector
+	}
+	return nil
+}
+
+// validate checks the configuration and prepares it for use.
+func (cfg *Config) validate() error {
+	if cfg.Backend != backendIngressRoute {
+		return fmt.Errorf("backend: unknown backend %q, expected %s", cfg.Backend, backendIngressRoute)
+	}
+	if len(cfg.Detection.Selector) == 0 {
+		return fmt.Errorf("detection.selector: must not be empty, it is what tells driver services apart")
+	}
+	if cfg.Hostname.Template == "" {
+		cfg.Hostname.Template = defaultHostTemplate
+	}
+	tmpl, err := template.New("hostname").Option("missingkey=error").Parse(cfg.Hostname.Template)
+	if err != nil {
+		return fmt.Errorf("hostname.template: %s", err.Error())
+	}
+	cfg.hostTemplate = tmpl
+	host, err := cfg.sparkUIHostFor(sparkUIHostData{Namespace: "spark", DriverService: "app-driver-svc",
+		AppName: "app", AppID: "spark-0123456789abcdef"}, cfg.Hostname.Suffix)
+	if err != nil {
+		return fmt.Errorf("hostname.template: %s", err.Error())
+	}
+	if errs := validation.IsDNS1123Subdomain(host); len(errs) > 0 {
+		return fmt.Errorf("hostname: example host %q is invalid: %s", host, strings.Join(errs, ", "))
+	}
+	if _, err := time.ParseDuration(cfg.Timeouts.Request); err != nil {
+		return fmt.Errorf("timeouts.request: %s", err.Error())
+	}
+	if err := cfg.validateRoutePolicy(); err != nil {
+		return err
+	}
+	if cfg.NetworkPolicy.Enabled && len(cfg.NetworkPolicy.From) == 0 {
+		return fmt.Errorf("networkPolicy.from: must not be empty, it is who may reach the spark uis")
+	}
+	for i, peer := range cfg.NetworkPolicy.From {
+		if peer.NamespaceSelector == nil && peer.PodSelector == nil {
+			return fmt.Errorf("networkPolicy.from[%d]: needs a namespaceSelector or a podSelector", i)
+		}
+	}
+	if err := cfg.validateDNS(); err != nil {
+		return err
+	}
+	ranges, err := parseSourceRanges(strings.Join(cfg.Security.SourceRanges, ","))
+	if err != nil {
+		return fmt.Errorf("security.sourceRanges: %s", err.Error())
+	}
+	cfg.Security.SourceRanges = ranges
+	return nil
+}
+
+// validateDNS checks the dns mode and the load balancer service its records point at.
+func (cfg *Config) validateDNS() error {
+	switch cfg.DNS.Mode {
+	case "":
+		return nil
+	case dnsModeAnnotations, dnsModeDNSEndpoint:
+	default:
+		return fmt.Errorf("dns.mode: unknown mode %q, expected %s or %s", cfg.DNS.Mode, dnsModeAnnotations,
+			dnsModeDNSEndpoint)
+	}
+	if parts := strings.Split(cfg.DNS.LoadBalancer, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("dns.loadBalancer: %q is not the namespace/name of the envoy service", cfg.DNS.LoadBalancer)
+	}
+	if cfg.DNS.TTL < 0 {
+		return fmt.Errorf("dns.ttl: must not be negative")
+	}
+	return nil
+}
+
+// envoyRetryConditions are the retry conditions of the envoy router.
+var envoyRetryConditions = map[string]bool{
+	"5xx": true, "gateway-error": true, "connect-failure": true, "retriable-4xx": true,
+	"refused-stream": true, "reset": true, "retriable-status-codes": true,
+}
+
+// validateRoutePolicy checks the timeouts, retries and health check, and warns about the fields
+// the backend can not render.
+func (cfg *Config) validateRoutePolicy() error {
+	for field, value := range map[string]string{"timeouts.perTry": cfg.Timeouts.PerTry, "timeouts.idle": cfg.Timeouts.Idle} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s: %s", field, err.Error())
+		}
+	}
+	if cfg.Retries.Count < 0 {
+		return fmt.Errorf("retries.count: must not be negative")
+	}
+	for _, on := range cfg.Retries.On {
+		if !envoyRetryConditions[on] {
+			return fmt.Errorf("retries.on: unknown retry condition %q", on)
+		}
+	}
+	hc := cfg.HealthCheck
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("healthCheck.path: %q must start with /", hc.Path)
+	}
+	for field, value := range map[string]string{"healthCheck.interval": hc.Interval, "healthCheck.timeout": hc.Timeout} {
+		if _, err := parseSeconds(value); err != nil {
+			return fmt.Errorf("%s: %s", field, err.Error())
+		}
+	}
+	if hc.UnhealthyThreshold < 0 || hc.HealthyThreshold < 0 {
+		return fmt.Errorf("healthCheck: thresholds must not be negative")
+	}
+	for _, field := range cfg.unsupportedRoutePolicy() {
+		klog.Warningf("%s is not supported by the %s backend, ignoring it", field, cfg.Backend)
+	}
+	return nil
+}
+
+// unsupportedRoutePolicy lists the route policy fields set in the configuration that its backend
+// can not render.
+func (cfg *Config) unsupportedRoutePolicy() []string {
+	var fields []string
+	if cfg.Backend == backendIngressRoute {
+		// IngressRoute v1beta1 only has a request timeout, and retries on envoy's 5xx condition.
+		if cfg.Timeouts.Idle != "" {
+			fields = append(fields, "timeouts.idle")
+		}
+		if len(cfg.Retries.On) > 0 {
+			fields = append(fields, "retries.on")
+		}
+	}
+	return fields
+}
+
+// parseSeconds parses a duration of whole seconds, zero when empty.
+func parseSeconds(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, fmt.Errorf("%q must be a whole number of seconds", value)
+	}
+	return int64(d / time.Second), nil
+}
+
+// equal reports whether two configurations are the same.
+func (cfg *Config) equal(other *Config) bool {
+	a, b := *cfg, *other
+	a.hostTemplate, b.hostTemplate = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// isSparkDriverService reports whether a service is a spark driver service, its name ends
+// with driverServiceSuffix, it selects the driver pod and it is in a watched namespace.
+func (cfg *Config) isSparkDriverService(service *corev1.Service) bool {
+	if !strings.HasSuffix(service.Name, driverServiceSuffix) || !cfg.watchesNamespace(service.Namespace) {
+		return false
+	}
+	if service.Spec.Selector == nil {
+		return false
+	}
+	return labels.SelectorFromSet(cfg.Detection.Selector).Matches(labels.Set(service.Spec.Selector))
+}
+
+func (cfg *Config) watchesNamespace(namespace string) bool {
+	for _, ns := range cfg.Detection.ExcludeNamespaces {
+		if ns == namespace {
+			return false
+		}
+	}
+	if len(cfg.Detection.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range cfg.Detection.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// sparkUIHost is the host name the spark ui of a driver is exposed on, suffix is the host suffix
+// of its exposure options.
+func (cfg *Config) sparkUIHost(driver *corev1.Service, suffix string) string {
+	host, err := cfg.sparkUIHostFor(sparkUIHostData{
+		Namespace:     driver.Namespace,
+		DriverService: driver.Name,
+		AppName:       strings.TrimSuffix(driver.Name, driverServiceSuffix),
+		AppID:         driver.Spec.Selector[sparkAppSelectorLabel],
+	}, suffix)
+	if err != nil {
+		// validate executed the template with the same fields, this does not happen.
+		klog.Errorf("Hostname template failed for %s/%s: %s", driver.Namespace, driver.Name, err.Error())
+		return driver.Name + suffix
+	}
+	return host
+}
+
+func (cfg *Config) sparkUIHostFor(data sparkUIHostData, suffix string) (string, error) {
+	var buf bytes.Buffer
+	if err := cfg.hostTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.ToLower(buf.String()) + suffix, nil
+}
+
+// exposureOptions are the exposure options of the drivers without annotations.
+func (cfg *Config) exposureOptions() ExposureOptions {
+	return ExposureOptions{
+		HostSuffix:     cfg.Hostname.Suffix,
+		ReadOnly:       cfg.Security.ReadOnly,
+		SourceRanges:   cfg.Security.SourceRanges,
+		RequestTimeout: cfg.Timeouts.Request,
+		PerTryTimeout:  cfg.Timeouts.PerTry,
+		Retries:        cfg.Retries.Count,
+		HealthCheck:    cfg.HealthCheck,
+		ExternalDNSTTL: cfg.DNS.TTL,
+	}
+}
+
+// requestTimeout is the parsed request timeout, validate checked it.
+func (cfg *Config) requestTimeout() time.Duration {
+	timeout, _ := time.ParseDuration(cfg.Timeouts.Request)
+	return timeout
+}
+
+// watchConfig reloads the configuration file when its content changes, an invalid file is
+// logged and the previous configuration kept. It is polled rather than watched, so it works
+// with the symlink swaps of configmap volumes.
+func (c *Controller) watchConfig(path string) func() {
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		klog.Errorf("Read config %s failed: %s", path, err.Error())
+	}
+	return func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			klog.Errorf("Read config %s failed: %s", path, err.Error())
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		last = data
+		cfg, err := loadConfig(path)
+		if err != nil {
+			klog.Errorf("Invalid config %s, keeping the previous one: %s", path, err.Error())
+			return
+		}
+		klog.Infof("Config %s changed, reloading it", path)
+		c.SetConfig(cfg)
+	}
+}
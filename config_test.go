@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestConfig returns the configuration of the controller tests.
+func newTestConfig(t *testing.T) *Config {
+	cfg := &Config{
+		Detection: DetectionConfig{Selector: map[string]string{"spark-role": "driver"}},
+		Backend:   backendIngressRoute,
+		Hostname:  HostnameConfig{Suffix: hostSuffixTest},
+		Timeouts:  TimeoutsConfig{Request: requestTimeoutTest},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	return cfg
+}
+
+func TestConfigMerge(t *testing.T) {
+	cfg := newTestConfig(t)
+	err := cfg.merge([]byte(`
+detection:
+  selector:
+    spark-role: driver
+    team: data
+  excludeNamespaces: [kube-system]
+hostname:
+  suffix: .spark-ui.example.com
+  template: "{{.AppName}}-{{.Namespace}}"
+security:
+  readOnly: true
+  sourceRanges: [10.0.0.1]
+`))
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if cfg.Timeouts.Request != requestTimeoutTest {
+		t.Errorf("expected the request timeout to be kept, got %q", cfg.Timeouts.Request)
+	}
+	if len(cfg.Security.SourceRanges) != 1 || cfg.Security.SourceRanges[0] != "10.0.0.1/32" {
+		t.Errorf("expected normalized source ranges, got %v", cfg.Security.SourceRanges)
+	}
+
+	driver := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl-driver-svc", Namespace: "spark"},
+		Spec: corev1.ServiceSpec{Selector: map[string]string{
+			"spark-role": "driver", "team": "data", sparkAppSelectorLabel: "spark-1",
+		}},
+	}
+	if !cfg.isSparkDriverService(driver) {
+		t.Errorf("expected %s to be a driver service", driver.Name)
+	}
+	if host := cfg.sparkUIHost(driver, cfg.Hostname.Suffix); host != "etl-spark.spark-ui.example.com" {
+		t.Errorf("unexpected host %s", host)
+	}
+	delete(driver.Spec.Selector, "team")
+	if cfg.isSparkDriverService(driver) {
+		t.Errorf("expected the selector of the config to replace the default one")
+	}
+	driver.Spec.Selector["team"] = "data"
+	driver.Namespace = "kube-system"
+	if cfg.isSparkDriverService(driver) {
+		t.Errorf("expected excluded namespaces to be ignored")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, doc := range []string{
+		"backend: ingress",
+		"hostname:\n  template: \"{{.Nope}}\"",
+		"hostname:\n  template: \"{{.AppName}}_{{.Namespace}}\"",
+		"timeouts:\n  request: forever",
+		"security:\n  sourceRanges: [10.0.0.0/33]",
+		"detection:\n  selector: {}",
+		"timeouts:\n  perTry: soon",
+		"retries:\n  count: -1",
+		"retries:\n  on: [everything]",
+		"healthCheck:\n  path: healthz",
+		"healthCheck:\n  path: /\n  interval: 1500ms",
+		"networkPolicy:\n  enabled: true",
+		"networkPolicy:\n  from: [{}]",
+		"dns:\n  mode: route53",
+		"dns:\n  mode: dnsendpoint",
+		"dns:\n  mode: annotations\n  loadBalancer: envoy",
+		"unknown: true",
+	} {
+		cfg := newTestConfig(t)
+		if err := cfg.merge([]byte(doc)); err == nil {
+			err = cfg.validate()
+			if err == nil {
+				t.Errorf("expected %q to be invalid", doc)
+			}
+		}
+	}
+}
+
+func TestConfigRoutePolicy(t *testing.T) {
+	cfg := newTestConfig(t)
+	err := cfg.merge([]byte(`
+timeouts:
+  perTry: 20s
+  idle: 5m
+retries:
+  count: 2
+healthCheck:
+  path: /api/v1/version
+  interval: 10s
+  unhealthyThreshold: 3
+`))
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if unsupported := cfg.unsupportedRoutePolicy(); len(unsupported) != 1 || unsupported[0] != "timeouts.idle" {
+		t.Errorf("expected the idle timeout to be unsupported, got %v", unsupported)
+	}
+
+	driver := newSparkDriverService("test-driver-svc")
+	uiService := NewSparkUIService(driver, ExposureOptions{})
+	route := NewSparkUIIngressRoute(uiService, "test", driver, cfg.exposureOptions())
+	retry := route.Spec.Routes[0].RetryPolicy
+	if retry == nil || retry.NumRetries != 2 || retry.PerTryTimeout != "20s" {
+		t.Errorf("unexpected retry policy %+v", retry)
+	}
+	hc := route.Spec.Routes[0].Services[0].HealthCheck
+	if hc == nil || hc.Path != "/api/v1/version" || hc.IntervalSeconds != 10 || hc.TimeoutSeconds != 0 ||
+		hc.UnhealthyThresholdCount != 3 {
+		t.Errorf("unexpected health check %+v", hc)
+	}
+}
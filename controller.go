@@ -7,38 +7,87 @@ import (
 	contourinformerssv1 "github.com/heptio/contour/apis/generated/informers/externalversions/contour/v1beta1"
 	contourlistersv1 "github.com/heptio/contour/apis/generated/listers/contour/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	coreinformerv1 "k8s.io/client-go/informers/core/v1"
+	networkinginformerv1 "k8s.io/client-go/informers/networking/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	networkinglisterv1 "k8s.io/client-go/listers/networking/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
+	controllerAgentName  = "spark-ui-controller"
 	driverServiceSuffix  = "-driver-svc"
 	sparkUIServiceSuffix = "-ui-svc"
 	ingressRouteSuffix   = "-ingress"
+	sparkUIPortName      = "spark-driver-ui-port"
+	sparkUIPort          = 4040
 )
 
 type Controller struct {
 	// kubeclientset is a standard kubernetes clientset
-	kubeclientset       kubernetes.Interface
-	contourclientset    contourclientset.Interface
-	servicesSynced      cache.InformerSynced
-	servicesLister      corelisterv1.ServiceLister
-	ingressRoutesSynced cache.InformerSynced
-	ingressRoutesLister contourlistersv1.IngressRouteLister
-	workqueue           workqueue.RateLimitingInterface
-	hostsuffix          string
-	requestTimeout      string
+	kubeclientset    kubernetes.Interface
+	contourclientset contourclientset.Interface
+	dynamicclientset dynamic.Interface
+	servicesSynced   cache.InformerSynced
+	servicesLister   corelisterv1.ServiceLister
+	namespacesSynced cache.InformerSynced
+	namespacesLister corelisterv1.NamespaceLister
+	podsSynced       cache.InformerSynced
+	podsLister       corelisterv1.PodLister
+	endpointsSynced  cache.InformerSynced
+	endpointsLister  corelisterv1.EndpointsLister
+	// networkPoliciesSynced and networkPoliciesLister are the ones created for drivers.
+	networkPoliciesSynced cache.InformerSynced
+	networkPoliciesLister networkinglisterv1.NetworkPolicyLister
+	// ingressRoutesDisabled is set when contour's crd is not installed, the lister is then empty.
+	ingressRoutesDisabled bool
+	ingressRoutesSynced   cache.InformerSynced
+	ingressRoutesLister   contourlistersv1.IngressRouteLister
+	// the exposure policies are unstructured, see policy.go.
+	policiesSynced        cache.InformerSynced
+	policiesLister        cache.GenericLister
+	clusterPoliciesSynced cache.InformerSynced
+	clusterPoliciesLister cache.GenericLister
+	uiEndpointsSynced     cache.InformerSynced
+	uiEndpointsLister     cache.GenericLister
+	workqueue             workqueue.RateLimitingInterface
+	configLock            sync.RWMutex
+	config                *Config
+	historyServerURL      string
+	historyRedirect       HistoryRedirectOptions
+	snapshots             *SnapshotStore
+	sweeper               SweeperOptions
+	prober                *prober
+	recorder              record.EventRecorder
+	dryRun                bool
+	events                *sparkUIEventBroadcaster
+	// unmanagedObjects are the resource versions of the unmanaged objects warned about, keyed by
+	// driver service, kind and name.
+	unmanagedLock    sync.Mutex
+	unmanagedObjects map[string]string
+	// dryRunActions are the hashes of the dry run actions last reported, keyed by namespace, name
+	// and kind of the object.
+	dryRunLock    sync.Mutex
+	dryRunActions map[string]uint64
 }
 
 // Run is the main path of execution for the controller loop
@@ -51,10 +100,29 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 		return fmt.Errorf("Error syncing cache")
 
 	}
+	c.deleteSparkUIDNSEndpoints()
 	klog.Info("Starting workers")
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
+	if c.historyRedirect.Enabled {
+		go wait.Until(c.expireHistoryRoutes, time.Minute, stopCh)
+	}
+	if c.snapshots != nil {
+		go wait.Until(c.snapshotRunningDrivers, c.snapshots.interval, stopCh)
+	}
+	if c.sweeper.Interval > 0 {
+		go wait.Until(c.sweepOrphans, c.sweeper.Interval, stopCh)
+	}
+	if c.prober.opts.Interval > 0 {
+		go wait.Until(c.probeSparkUIs, c.prober.opts.Interval, stopCh)
+	}
+	if c.policiesLister != nil || c.clusterPoliciesLister != nil {
+		go wait.Until(c.updateExposurePolicyStatuses, policyStatusInterval, stopCh)
+	}
+	if c.uiEndpointsLister != nil {
+		go wait.Until(c.updateSparkUIEndpoints, endpointStatusInterval, stopCh)
+	}
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
@@ -63,15 +131,35 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 // NewController returns a new sample controller
 func NewController(
-	hostsuffix string,
-	requestTimeout string,
+	config *Config,
+	historyServerURL string,
+	historyRedirect HistoryRedirectOptions,
+	snapshots *SnapshotStore,
+	sweeper SweeperOptions,
+	probe ProberOptions,
+	dryRun bool,
 	kubeclientset kubernetes.Interface,
 	contourclientset contourclientset.Interface,
+	dynamicclientset dynamic.Interface,
 	servicesInformer coreinformerv1.ServiceInformer,
-	ingressRoutesInformer contourinformerssv1.IngressRouteInformer) *Controller {
+	namespacesInformer coreinformerv1.NamespaceInformer,
+	podsInformer coreinformerv1.PodInformer,
+	endpointsInformer coreinformerv1.EndpointsInformer,
+	networkPoliciesInformer networkinginformerv1.NetworkPolicyInformer,
+	ingressRoutesInformer contourinformerssv1.IngressRouteInformer,
+	policiesInformer informers.GenericInformer,
+	clusterPoliciesInformer informers.GenericInformer,
+	uiEndpointsInformer informers.GenericInformer) *Controller {
 
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	if !dryRun {
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	}
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
 	servicesInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			key, err := cache.MetaNamespaceKeyFunc(obj)
@@ -96,25 +184,85 @@ func NewController(
 		},
 	})
 	controller := &Controller{
-		kubeclientset:       kubeclientset,
-		contourclientset:    contourclientset,
-		servicesSynced:      servicesInformer.Informer().HasSynced,
-		servicesLister:      servicesInformer.Lister(),
-		ingressRoutesSynced: ingressRoutesInformer.Informer().HasSynced,
-		ingressRoutesLister: ingressRoutesInformer.Lister(),
-		workqueue:           queue,
-		hostsuffix:          hostsuffix,
-		requestTimeout:      requestTimeout,
+		kubeclientset:         kubeclientset,
+		contourclientset:      contourclientset,
+		dynamicclientset:      dynamicclientset,
+		servicesSynced:        servicesInformer.Informer().HasSynced,
+		servicesLister:        servicesInformer.Lister(),
+		namespacesSynced:      namespacesInformer.Informer().HasSynced,
+		namespacesLister:      namespacesInformer.Lister(),
+		podsSynced:            podsInformer.Informer().HasSynced,
+		podsLister:            podsInformer.Lister(),
+		endpointsSynced:       endpointsInformer.Informer().HasSynced,
+		endpointsLister:       endpointsInformer.Lister(),
+		networkPoliciesSynced: networkPoliciesInformer.Informer().HasSynced,
+		networkPoliciesLister: networkPoliciesInformer.Lister(),
+		workqueue:             queue,
+		config:                config,
+		historyServerURL:      historyServerURL,
+		historyRedirect:       historyRedirect,
+		snapshots:             snapshots,
+		sweeper:               sweeper,
+		prober:                newProber(probe),
+		recorder:              recorder,
+		dryRun:                dryRun,
+		events:                newSparkUIEventBroadcaster(),
+		unmanagedObjects:      map[string]string{},
+		dryRunActions:         map[string]uint64{},
+	}
+	// the informers of the crds that are not installed are nil, the features built on them are
+	// disabled rather than blocking the cache sync.
+	controller.policiesSynced, controller.policiesLister = crdInformer(policiesInformer)
+	controller.clusterPoliciesSynced, controller.clusterPoliciesLister = crdInformer(clusterPoliciesInformer)
+	controller.uiEndpointsSynced, controller.uiEndpointsLister = crdInformer(uiEndpointsInformer)
+	// without contour the spark uis are only served by the built-in proxy, the routes read as
+	// not found.
+	if ingressRoutesInformer != nil {
+		controller.ingressRoutesSynced = ingressRoutesInformer.Informer().HasSynced
+		controller.ingressRoutesLister = ingressRoutesInformer.Lister()
+	} else {
+		controller.ingressRoutesDisabled = true
+		controller.ingressRoutesSynced = func() bool { return true }
+		controller.ingressRoutesLister = contourlistersv1.NewIngressRouteLister(cache.NewIndexer(
+			cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}))
 	}
+	if policiesInformer != nil {
+		policiesInformer.Informer().AddEventHandler(controller.policyEventHandler())
+	}
+	if clusterPoliciesInformer != nil {
+		clusterPoliciesInformer.Informer().AddEventHandler(controller.policyEventHandler())
+	}
+	// the routes wait for the driver pod to be ready, and finished drivers are redirected to the
+	// history server.
+	podsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueDriverServicesForPod,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			controller.enqueueDriverServicesForPod(newObj)
+		},
+	})
+	endpointsInformer.Informer().AddEventHandler(controller.endpointsEventHandler())
+	namespacesInformer.Informer().AddEventHandler(controller.namespaceEventHandler())
+	servicesInformer.Informer().AddEventHandler(controller.loadBalancerEventHandler())
 	return controller
 }
+
+// crdInformer returns the synced func and the lister of the informer of a crd, a nil informer is
+// always synced and has a nil lister.
+func crdInformer(informer informers.GenericInformer) (cache.InformerSynced, cache.GenericLister) {
+	if informer == nil {
+		return func() bool { return true }, nil
+	}
+	return informer.Informer().HasSynced, informer.Lister()
+}
+
 func (c *Controller) HasSynced() bool {
-	return c.servicesSynced() && c.ingressRoutesSynced()
+	return c.servicesSynced() && c.namespacesSynced() && c.podsSynced() && c.ingressRoutesSynced() &&
+		c.endpointsSynced() && c.networkPoliciesSynced() && c.policiesSynced() && c.clusterPoliciesSynced() && c.uiEndpointsSynced()
 }
 
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
-// workqueue. 
+// workqueue.
 func (c *Controller) runWorker() {
 	for c.processNextWorkItem() {
 	}
@@ -192,15 +340,36 @@ func (c *Controller) syncHandler(key string) error {
 		if errors.IsNotFound(err) {
 			runtime.HandleError(fmt.Errorf("service '%s' in work queue no longer exists", key))
 			// this should a spark driver service deleted event.
-			// service not found depend owner reference to garbage collect ui service and ingress route
+			// the driver service owns the ui service which owns the ingress route, so they are garbage
+			// collected, but objects created before that or with a missing owner are deleted here.
+			if err := c.unpublishSparkUI(namespace, name); err != nil {
+				return err
+			}
+			c.forgetDryRunActions(namespace, name)
 			return nil
 		}
 		return err
 	}
-	// spark driver svc should has a selector spark-role: driver
-	if service.Spec.Selector["spark-role"] != "driver" {
-		klog.Infof("Get service: %s, has not a selector spark-role: driver, ignoring it", service)
-		return nil
+	// spark driver svc should select the driver pod and be in a watched namespace, the ui of a
+	// driver the detection rules stopped matching, e.g. after a config reload, is unpublished.
+	if !c.isSparkDriverService(service) {
+		klog.Infof("Get service: %s/%s, not a spark driver service of the configured detection rules, "+
+			"ignoring it", namespace, name)
+		return c.unpublishSparkUI(namespace, name)
+	}
+
+	// a finished driver keeps its link working by redirecting to the history server
+	if c.historyRedirect.Enabled {
+		pod, err := c.getSparkDriverPod(service)
+		if err != nil {
+			return err
+		}
+		if pod != nil && sparkDriverFinished(pod) {
+			if c.snapshots != nil && pod.DeletionTimestamp != nil {
+				c.takeFinalSnapshot(service)
+			}
+			return c.redirectSparkUIToHistory(service)
+		}
 	}
 
 	//check
@@ -209,6 +378,77 @@ func (c *Controller) syncHandler(key string) error {
 	return err2
 }
 
+// getConfig returns the current configuration, it must not be modified.
+func (c *Controller) getConfig() *Config {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
+	return c.config
+}
+
+// SetConfig replaces the configuration and re-enqueues the driver services detected by the old
+// or the new one, so their spark ui services and routes are updated or deleted.
+func (c *Controller) SetConfig(config *Config) {
+	c.configLock.Lock()
+	old := c.config
+	c.config = config
+	c.configLock.Unlock()
+	if old.equal(config) {
+		return
+	}
+	if old.DNS.Mode == dnsModeDNSEndpoint && config.DNS.Mode != dnsModeDNSEndpoint {
+		c.deleteSparkUIDNSEndpoints()
+	}
+	services, err := c.servicesLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List services failed: %s", err.Error())
+		return
+	}
+	for _, svc := range services {
+		if old.isSparkDriverService(svc) || config.isSparkDriverService(svc) {
+			c.workqueue.Add(svc.Namespace + "/" + svc.Name)
+		}
+	}
+}
+
+// isSparkDriverService reports whether a service is a spark driver service of the current
+// detection rules.
+func (c *Controller) isSparkDriverService(service *corev1.Service) bool {
+	return c.getConfig().isSparkDriverService(service)
+}
+
+// getSparkDriverPod returns the pod selected by a driver service, nil when there is none.
+func (c *Controller) getSparkDriverPod(driver *corev1.Service) (*corev1.Pod, error) {
+	pods, err := c.podsLister.Pods(driver.Namespace).List(labels.SelectorFromSet(driver.Spec.Selector))
+	if err != nil || len(pods) == 0 {
+		return nil, err
+	}
+	return pods[0], nil
+}
+
+// publishSparkUIAdded tells the api watchers about a newly exposed spark ui.
+func (c *Controller) publishSparkUIAdded(driver *corev1.Service) {
+	event := SparkUIEvent{Type: eventAdded, Namespace: driver.Namespace, Name: driver.Name}
+	if ui, err := c.newSparkUI(driver); err == nil {
+		event.UI = &ui
+	}
+	c.events.publish(event)
+}
+
+// unpublishSparkUI deletes the spark ui and the sparkuiendpoint of a driver and tells the api
+// watchers once it is gone, only for the drivers whose spark ui was exposed.
+func (c *Controller) unpublishSparkUI(namespace, name string) error {
+	if err := c.deleteSparkUIOfDriver(namespace, name); err != nil {
+		return err
+	}
+	if err := c.deleteSparkUIEndpointOfDriver(namespace, name); err != nil {
+		return err
+	}
+	if c.events.setExposed(namespace, name, false) {
+		c.events.publish(SparkUIEvent{Type: eventDeleted, Namespace: namespace, Name: name})
+	}
+	return nil
+}
+
 // spark ui name without namespace
 func getSparkUIServiceName(name string) string {
 	return strings.Replace(name, driverServiceSuffix, sparkUIServiceSuffix, 1)
@@ -219,91 +459,315 @@ func (c *Controller) getSparkUIIngressRouteName(name string) string {
 	return name + ingressRouteSuffix
 }
 
-//create spark ui and ingress route from driver svc namespace and name
+// create spark ui and ingress route from driver svc namespace and name
 func (c *Controller) createSparkUIServiceIfNotExists(namespace, name string) error {
-	sparkUIServiceName := getSparkUIServiceName(name)
-	_, err1 := c.servicesLister.Services(namespace).Get(sparkUIServiceName)
-	if err1 != nil {
-		if errors.IsNotFound(err1) {
-			klog.Infof("spark ui service with name: %s is not found, now create one ...", sparkUIServiceName)
-			driver, err2 := c.servicesLister.Services(namespace).Get(name)
-			if err2 == nil {
-				uiService, err3 := c.kubeclientset.CoreV1().Services(namespace).Create(NewSparkUIService(driver))
-				if err3 != nil {
-					return err3
-				}
-				// if spark ui service is not defined, related ingress route should not exists too.
-				ingressName := c.getSparkUIIngressRouteName(sparkUIServiceName)
-				ingressRoute, err4 := c.ingressRoutesLister.IngressRoutes(namespace).Get(ingressName)
-				if err4 != nil {
-					if errors.IsNotFound(err4) {
-						klog.Infof("spark ui ingress route with name: %s is not found, now create one ...", ingressName)
-						_, _ = c.contourclientset.ContourV1beta1().IngressRoutes(namespace).Create(
-							NewSparkUIIngressRoute(uiService, c.hostsuffix, driver))
-					}
-				} else {
-					klog.Infof("spark ui ingress route with name: %s already exists", ingressRoute.Name)
-					return nil
-				}
+	driver, err := c.servicesLister.Services(namespace).Get(name)
+	if err != nil {
+		return err
+	}
+	opts, err := c.exposureOptions(driver)
+	if err != nil {
+		// a spark ui published before the options became invalid may not be exposed without them.
+		c.recorder.Eventf(driver, corev1.EventTypeWarning, reasonInvalidExposureOptions,
+			"spark ui is not published, %s", err.Error())
+		if err := c.unpublishSparkUI(namespace, name); err != nil {
+			return err
+		}
+		return err
+	}
+	uiService, created, err := c.ensureSparkUIService(driver, opts)
+	if err != nil || uiService == nil {
+		return err
+	}
+	// the route is only published once envoy can reach the ui port.
+	if err := c.ensureSparkUINetworkPolicy(driver); err != nil {
+		return err
+	}
+	routeCreated, err := c.ensureSparkUIIngressRoute(uiService, driver, opts)
+	if err != nil {
+		return err
+	}
+	if err := c.ensureSparkUIDNSEndpoint(driver, opts); err != nil {
+		return err
+	}
+	if err := c.syncSparkUIEndpoint(driver); err != nil {
+		// the endpoint only reports on the spark ui, it is retried by updateSparkUIEndpoints.
+		klog.Errorf("Sync sparkuiendpoint of %s/%s failed: %s", namespace, name, err.Error())
+	}
+	// a driver found exposed, e.g. after a restart, is remembered without being announced again.
+	if c.events.setExposed(namespace, name, true) && (created || routeCreated) {
+		c.publishSparkUIAdded(driver)
+	}
+	return nil
+}
 
+// ensureSparkUIService returns the spark ui service of a driver, creating it when it does not
+// exist and updating it when it differs from the desired one, e.g. after a config reload. A
+// service with the same name that is not managed by the controller is left alone, unless it
+// carries the adoption annotation, and nil is returned.
+func (c *Controller) ensureSparkUIService(driver *corev1.Service, opts ExposureOptions) (*corev1.Service, bool, error) {
+	sparkUIServiceName := getSparkUIServiceName(driver.Name)
+	existing, err := c.servicesLister.Services(driver.Namespace).Get(sparkUIServiceName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, false, err
+		}
+		klog.Infof("spark ui service with name: %s is not found, now create one ...", sparkUIServiceName)
+		uiService, err := c.createService(NewSparkUIService(driver, opts))
+		return uiService, err == nil, err
+	}
+	if isManagedBy(existing.ObjectMeta, driver.Name) {
+		desired := NewSparkUIService(driver, opts)
+		if drift := serviceDrift(existing, desired); drift != "" {
+			klog.Infof("spark ui service with name: %s differs from the desired one: %s, updating it",
+				sparkUIServiceName, drift)
+			updated := existing.DeepCopy()
+			updated.Spec.Selector = desired.Spec.Selector
+			updated.Spec.Type = desired.Spec.Type
+			updated.Spec.Ports = desired.Spec.Ports
+			updated.Spec.LoadBalancerSourceRanges = desired.Spec.LoadBalancerSourceRanges
+			uiService, err := c.updateService(existing, updated)
+			return uiService, false, err
+		}
+		return existing, false, nil
+	}
+	if !adoptionRequested(existing.ObjectMeta) {
+		c.warnUnmanagedObject(driver, "service", existing.ObjectMeta,
+			"service %s exists and is not managed by %s, annotate it with %s=true to adopt it",
+			sparkUIServiceName, managedByValue, adoptAnnotation)
+		return nil, false, nil
+	}
+
+	klog.Infof("spark ui service with name: %s has the %s annotation, adopting it", sparkUIServiceName,
+		adoptAnnotation)
+	adopted := existing.DeepCopy()
+	desired := NewSparkUIService(driver, opts)
+	adoptObjectMeta(&adopted.ObjectMeta, desired.ObjectMeta)
+	adopted, err = c.updateService(existing, adopted)
+	if err != nil {
+		return nil, false, err
+	}
+	c.recorder.Eventf(driver, corev1.EventTypeNormal, reasonAdopted, "adopted service %s", sparkUIServiceName)
+	return adopted, false, nil
+}
+
+// ensureSparkUIIngressRoute creates the ingress route of a spark ui service when it does not exist
+// and updates it when it differs from the desired one, following the same rules for unmanaged
+// routes as ensureSparkUIService.
+func (c *Controller) ensureSparkUIIngressRoute(uiService, driver *corev1.Service, opts ExposureOptions) (bool, error) {
+	if c.ingressRoutesDisabled {
+		return false, nil
+	}
+	ingressName := c.getSparkUIIngressRouteName(uiService.Name)
+	if reason := unenforceableOptions(opts); reason != "" {
+		return false, c.withholdSparkUIIngressRoute(driver, ingressName, reason)
+	}
+	existing, err := c.ingressRoutesLister.IngressRoutes(driver.Namespace).Get(ingressName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		pending, err := c.sparkUIPendingReason(driver)
+		if err != nil {
+			return false, err
+		}
+		if pending != "" {
+			// the pod and endpoints events enqueue the driver again once it is ready.
+			klog.Infof("spark ui ingress route with name: %s is pending, %s", ingressName, pending)
+			c.recorder.Eventf(driver, corev1.EventTypeNormal, reasonSparkUIPending, "spark ui is pending, %s", pending)
+			return false, nil
+		}
+		klog.Infof("spark ui ingress route with name: %s is not found, now create one ...", ingressName)
+		host := c.getConfig().sparkUIHost(driver, opts.HostSuffix)
+		_, err = c.createIngressRoute(NewSparkUIIngressRoute(uiService, host, driver, opts))
+		return err == nil, err
+	}
+	if isManagedBy(existing.ObjectMeta, driver.Name) {
+		desired := NewSparkUIIngressRoute(uiService, c.getConfig().sparkUIHost(driver, opts.HostSuffix), driver, opts)
+		upToDate := equality.Semantic.DeepEqual(existing.Spec, desired.Spec)
+		for _, key := range routeAnnotations {
+			upToDate = upToDate && existing.Annotations[key] == desired.Annotations[key]
+		}
+		// a route redirecting to the history server is left to expireHistoryRoutes.
+		if upToDate || existing.Annotations[historyAppIDAnnotation] != "" {
+			klog.V(4).Infof("spark ui ingress route with name: %s already exists", ingressName)
+			return false, nil
+		}
+		klog.Infof("spark ui ingress route with name: %s differs from the desired one, updating it", ingressName)
+		updated := existing.DeepCopy()
+		updated.Spec = desired.Spec
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		for _, key := range routeAnnotations {
+			if value, ok := desired.Annotations[key]; ok {
+				updated.Annotations[key] = value
+			} else {
+				delete(updated.Annotations, key)
 			}
-			return err2
 		}
-		return err1
+		_, err = c.updateIngressRoute(existing, updated)
+		return false, err
 	}
-	return nil
+	if !adoptionRequested(existing.ObjectMeta) {
+		c.warnUnmanagedObject(driver, "ingressroute", existing.ObjectMeta,
+			"ingress route %s exists and is not managed by %s, annotate it with %s=true to adopt it",
+			ingressName, managedByValue, adoptAnnotation)
+		return false, nil
+	}
+
+	klog.Infof("spark ui ingress route with name: %s has the %s annotation, adopting it", ingressName,
+		adoptAnnotation)
+	adopted := existing.DeepCopy()
+	desired := NewSparkUIIngressRoute(uiService, c.getConfig().sparkUIHost(driver, opts.HostSuffix), driver, opts)
+	adoptObjectMeta(&adopted.ObjectMeta, desired.ObjectMeta)
+	if _, err = c.updateIngressRoute(existing, adopted); err != nil {
+		return false, err
+	}
+	c.recorder.Eventf(driver, corev1.EventTypeNormal, reasonAdopted, "adopted ingress route %s", ingressName)
+	return false, nil
+}
+
+// withholdSparkUIIngressRoute deletes the managed route of a driver whose exposure options the
+// backend can not enforce, a route ignoring them would expose the spark ui to everyone.
+func (c *Controller) withholdSparkUIIngressRoute(driver *corev1.Service, ingressName, reason string) error {
+	c.recorder.Eventf(driver, corev1.EventTypeWarning, reasonSparkUIWithheld,
+		"spark ui is not published by the %s backend, %s", backendIngressRoute, reason)
+	existing, err := c.ingressRoutesLister.IngressRoutes(driver.Namespace).Get(ingressName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !isManagedBy(existing.ObjectMeta, driver.Name) {
+		return nil
+	}
+	klog.Infof("spark ui ingress route with name: %s can not enforce the exposure options, deleting it", ingressName)
+	err = c.deleteIngressRoute(driver.Namespace, ingressName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
 
-// construct spark ui Service from driver service namespace and name
-func NewSparkUIService(driver *corev1.Service) *corev1.Service {
-	return &corev1.Service{
+// construct spark ui Service from driver service namespace and name, the driver service is
+// its controller so it is garbage collected with the driver. a spark ui with source ranges is
+// a LoadBalancer whose cloud load balancer enforces them.
+func NewSparkUIService(driver *corev1.Service, opts ExposureOptions) *corev1.Service {
+	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:            getSparkUIServiceName(driver.Name),
-			Namespace:       driver.Namespace,
-			OwnerReferences: driver.OwnerReferences,
+			Name:      getSparkUIServiceName(driver.Name),
+			Namespace: driver.Namespace,
+			Labels:    managedLabels(driver.Name),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(driver, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: driver.Spec.Selector,
 			Type:     driver.Spec.Type,
 			Ports: []corev1.ServicePort{
 				{
-					Name:       "spark-driver-ui-port",
-					Port:       4040,
+					Name:       sparkUIPortName,
+					Port:       sparkUIPort,
 					Protocol:   corev1.ProtocolTCP,
-					TargetPort: intstr.FromInt(4040),
+					TargetPort: intstr.FromInt(sparkUIPort),
 				},
 			},
 		},
 	}
-
+	// the ingress route can not enforce an allowlist, the spark ui is published by a load balancer
+	// of its own instead.
+	if len(opts.SourceRanges) > 0 {
+		svc.Spec.Type = corev1.ServiceTypeLoadBalancer
+		svc.Spec.LoadBalancerSourceRanges = opts.SourceRanges
+	}
+	return svc
 }
-func NewSparkUIIngressRoute(uiService *corev1.Service, hostsuffix string,
-	driver *corev1.Service) *contourv1.IngressRoute {
+
+// NewSparkUIIngressRoute construct the ingress route exposing the spark ui service on
+// host, it is controlled by the spark ui service.
+func NewSparkUIIngressRoute(uiService *corev1.Service, host string,
+	driver *corev1.Service, opts ExposureOptions) *contourv1.IngressRoute {
+	var annotations map[string]string
+	if len(opts.ExternalDNSTargets) > 0 {
+		annotations = map[string]string{}
+		annotations[externalDNSTargetAnnotation] = strings.Join(opts.ExternalDNSTargets, ",")
+		if opts.ExternalDNSTTL > 0 {
+			annotations[externalDNSTTLAnnotation] = strconv.FormatInt(opts.ExternalDNSTTL, 10)
+		}
+	}
 	return &contourv1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:            uiService.Name + ingressRouteSuffix,
-			Namespace:       uiService.Namespace,
-			OwnerReferences: uiService.OwnerReferences,
+			Name:        uiService.Name + ingressRouteSuffix,
+			Namespace:   uiService.Namespace,
+			Labels:      managedLabels(driver.Name),
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(uiService, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
 		},
 		Spec: contourv1.IngressRouteSpec{
-			Routes: []contourv1.Route{
-				{
-					Match: "/",
-					TimeoutPolicy: &contourv1.TimeoutPolicy{
-						Request: requestTimeout,
-					},
-					Services: []contourv1.Service{
-						{
-							Name: uiService.Name,
-							Port: int(uiService.Spec.Ports[0].Port),
-						},
-					},
-				},
-			},
+			Routes: newSparkUIRoutes(uiService, opts),
 			VirtualHost: &contourv1.VirtualHost{
-				Fqdn: driver.Name + hostsuffix,
+				Fqdn: host,
+			},
+		},
+	}
+
+}
+
+// newSparkUIRoutes returns the routes forwarding to the spark ui service. The kill endpoints of a
+// read only spark ui get routes of their own, envoy prefers the longest match, rewriting them to a
+// path the spark ui does not serve. IngressRoute can not match on http methods, so unlike the
+// built-in proxy envoy does not reject the methods other than GET and HEAD.
+func newSparkUIRoutes(uiService *corev1.Service, opts ExposureOptions) []contourv1.Route {
+	route := contourv1.Route{
+		Match: "/",
+		TimeoutPolicy: &contourv1.TimeoutPolicy{
+			Request: opts.RequestTimeout,
+		},
+		Services: []contourv1.Service{
+			{
+				Name:        uiService.Name,
+				Port:        int(uiService.Spec.Ports[0].Port),
+				HealthCheck: newSparkUIHealthCheck(opts.HealthCheck),
 			},
 		},
 	}
+	if opts.Retries > 0 || opts.PerTryTimeout != "" {
+		route.RetryPolicy = &contourv1.RetryPolicy{
+			NumRetries:    opts.Retries,
+			PerTryTimeout: opts.PerTryTimeout,
+		}
+	}
+	routes := []contourv1.Route{route}
+	if opts.ReadOnly {
+		for _, kill := range sparkUIKillPaths {
+			blocked := route
+			blocked.Match = kill
+			blocked.PrefixRewrite = readOnlyRewritePath
+			blocked.RetryPolicy = nil
+			routes = append(routes, blocked)
+		}
+	}
+	return routes
+}
 
+// newSparkUIHealthCheck is the envoy health check of the ui service, nil when it is disabled.
+func newSparkUIHealthCheck(hc HealthCheckConfig) *contourv1.HealthCheck {
+	if hc.Path == "" {
+		return nil
+	}
+	// validate checked the durations.
+	interval, _ := parseSeconds(hc.Interval)
+	timeout, _ := parseSeconds(hc.Timeout)
+	return &contourv1.HealthCheck{
+		Path:                    hc.Path,
+		IntervalSeconds:         interval,
+		TimeoutSeconds:          timeout,
+		UnhealthyThresholdCount: uint32(hc.UnhealthyThreshold),
+		HealthyThresholdCount:   uint32(hc.HealthyThreshold),
+	}
 }
@@ -1,20 +1,27 @@
 package main
+
 // test code
 import (
+	"fmt"
 	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
 	contourfake "github.com/heptio/contour/apis/generated/clientset/versioned/fake"
 	contourinformers "github.com/heptio/contour/apis/generated/informers/externalversions"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	clientgotesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/diff"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -24,6 +31,8 @@ var (
 	noResyncPeriodFunc = func() time.Duration { return 0 }
 	hostSuffixTest     = "test"
 	requestTimeoutTest = "1s"
+	// testExposureOptions are the options of a driver without annotations.
+	testExposureOptions = ExposureOptions{HostSuffix: hostSuffixTest, RequestTimeout: requestTimeoutTest}
 )
 
 type fixture struct {
@@ -31,9 +40,17 @@ type fixture struct {
 
 	contourclient *contourfake.Clientset
 	kubeclient    *k8sfake.Clientset
+	dynamicclient *dynamicfake.FakeDynamicClient
 	// Objects to put in the store.
 	svcsLister []*corev1.Service
 	irsLister  []*contourv1.IngressRoute
+	nsLister   []*corev1.Namespace
+	podsLister []*corev1.Pod
+	epsLister  []*corev1.Endpoints
+	// policiesLister are exposure policies, namespaced or not.
+	policiesLister []*unstructured.Unstructured
+	// uiEndpointsLister are sparkuiendpoints, added to the dynamic client as well.
+	uiEndpointsLister []*unstructured.Unstructured
 	// Actions expected to happen on the client.
 	svcsactions []clientgotesting.Action
 	irsactions  []clientgotesting.Action
@@ -88,19 +105,64 @@ func (f *fixture) newController() (*Controller, contourinformers.SharedInformerF
 
 	contourI := contourinformers.NewSharedInformerFactory(f.contourclient, noResyncPeriodFunc())
 	k8sI := informers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
-
-	c := NewController(hostSuffixTest, requestTimeoutTest, f.kubeclient, f.contourclient, k8sI.Core().V1().Services(),
-		contourI.Contour().V1beta1().IngressRoutes())
+	// the policy informers are not started, the policies are added to their indexers and to the
+	// client for the status updates.
+	var policies []runtime.Object
+	for _, policy := range f.policiesLister {
+		policies = append(policies, policy.DeepCopy())
+	}
+	for _, endpoint := range f.uiEndpointsLister {
+		policies = append(policies, endpoint.DeepCopy())
+	}
+	f.dynamicclient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), policies...)
+	policyI := dynamicinformer.NewDynamicSharedInformerFactory(f.dynamicclient, noResyncPeriodFunc())
+
+	c := NewController(newTestConfig(f.t), "",
+		HistoryRedirectOptions{}, nil, SweeperOptions{}, ProberOptions{}, false, f.kubeclient, f.contourclient, f.dynamicclient,
+		k8sI.Core().V1().Services(), k8sI.Core().V1().Namespaces(), k8sI.Core().V1().Pods(), k8sI.Core().V1().Endpoints(),
+		k8sI.Networking().V1().NetworkPolicies(), contourI.Contour().V1beta1().IngressRoutes(), policyI.ForResource(exposurePolicyResource),
+		policyI.ForResource(clusterExposurePolicyResource), policyI.ForResource(sparkUIEndpointResource))
 	c.servicesSynced = alwaysReady
+	c.namespacesSynced = alwaysReady
+	c.podsSynced = alwaysReady
+	c.endpointsSynced = alwaysReady
+	c.networkPoliciesSynced = alwaysReady
+	c.recorder = &record.FakeRecorder{}
 	c.ingressRoutesSynced = alwaysReady
+	c.policiesSynced = alwaysReady
+	c.clusterPoliciesSynced = alwaysReady
+	c.uiEndpointsSynced = alwaysReady
 
 	for _, s := range f.svcsLister {
 		k8sI.Core().V1().Services().Informer().GetIndexer().Add(s)
 	}
 
+	for _, ns := range f.nsLister {
+		k8sI.Core().V1().Namespaces().Informer().GetIndexer().Add(ns)
+	}
+
+	for _, pod := range f.podsLister {
+		k8sI.Core().V1().Pods().Informer().GetIndexer().Add(pod)
+	}
+
+	for _, eps := range f.epsLister {
+		k8sI.Core().V1().Endpoints().Informer().GetIndexer().Add(eps)
+	}
+
 	for _, ir := range f.irsLister {
 		contourI.Contour().V1beta1().IngressRoutes().Informer().GetIndexer().Add(ir)
 	}
+
+	for _, policy := range f.policiesLister {
+		resource := exposurePolicyResource
+		if policy.GetNamespace() == "" {
+			resource = clusterExposurePolicyResource
+		}
+		policyI.ForResource(resource).Informer().GetIndexer().Add(policy)
+	}
+	for _, endpoint := range f.uiEndpointsLister {
+		policyI.ForResource(sparkUIEndpointResource).Informer().GetIndexer().Add(endpoint)
+	}
 	return c, contourI, k8sI
 }
 
@@ -169,6 +231,14 @@ func filterInformerActions(actions []clientgotesting.Action) []clientgotesting.A
 		if len(action.GetNamespace()) == 0 &&
 			(action.Matches("list", "services") ||
 				action.Matches("watch", "services") ||
+				action.Matches("list", "namespaces") ||
+				action.Matches("watch", "namespaces") ||
+				action.Matches("list", "pods") ||
+				action.Matches("watch", "pods") ||
+				action.Matches("list", "endpoints") ||
+				action.Matches("watch", "endpoints") ||
+				action.Matches("list", "networkpolicies") ||
+				action.Matches("watch", "networkpolicies") ||
 				action.Matches("list", "ingressroutes") ||
 				action.Matches("watch", "ingressroutes")) {
 			continue
@@ -226,17 +296,59 @@ func checkAction(expected, actual clientgotesting.Action, t *testing.T) {
 
 func (f *fixture) expectCreateSparkUIServiceAction(svc *corev1.Service) {
 	f.svcsactions = append(f.svcsactions, clientgotesting.NewCreateAction(schema.
-	GroupVersionResource{Resource: "services"}, svc.Namespace, svc))
+		GroupVersionResource{Resource: "services"}, svc.Namespace, svc))
 }
 
 func (f *fixture) expectUpdateSparkDriverServceAction(svc *corev1.Service) {
 	f.svcsactions = append(f.svcsactions, clientgotesting.NewUpdateAction(schema.
-	GroupVersionResource{Resource: "services"}, svc.Namespace, svc))
+		GroupVersionResource{Resource: "services"}, svc.Namespace, svc))
+}
+
+func (f *fixture) expectUpdateSparkUIIngressRouteAction(ir *contourv1.IngressRoute) {
+	f.irsactions = append(f.irsactions, clientgotesting.NewUpdateAction(schema.
+		GroupVersionResource{Resource: "ingressroutes"}, ir.Namespace, ir))
 }
 
 func (f *fixture) expectCreateSparkUIIngressRouteAction(ir *contourv1.IngressRoute) {
 	f.irsactions = append(f.irsactions, clientgotesting.NewCreateAction(schema.
-	GroupVersionResource{Resource: "ingressroutes"}, ir.Namespace, ir))
+		GroupVersionResource{Resource: "ingressroutes"}, ir.Namespace, ir))
+}
+
+func (f *fixture) expectDeleteServiceAction(namespace, name string) {
+	f.svcsactions = append(f.svcsactions, clientgotesting.NewDeleteAction(schema.
+		GroupVersionResource{Resource: "services"}, namespace, name))
+}
+
+func (f *fixture) expectDeleteIngressRouteAction(namespace, name string) {
+	f.irsactions = append(f.irsactions, clientgotesting.NewDeleteAction(schema.
+		GroupVersionResource{Resource: "ingressroutes"}, namespace, name))
+}
+
+// addReadyDriverPod adds a Ready driver pod selected by a driver service and the endpoints of its
+// ui service, so the route of the driver is published.
+func (f *fixture) addReadyDriverPod(driverService *corev1.Service) {
+	f.podsLister = append(f.podsLister, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "spark-driver-pod-name",
+			Namespace: driverService.Namespace,
+			Labels:    driverService.Spec.Selector,
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	})
+	f.epsLister = append(f.epsLister, &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSparkUIServiceName(driverService.Name),
+			Namespace: driverService.Namespace,
+		},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []corev1.EndpointPort{{Name: sparkUIPortName, Port: sparkUIPort}},
+		}},
+	})
 }
 
 func getKey(driverService *corev1.Service, t *testing.T) string {
@@ -254,12 +366,478 @@ func TestCreatesSparkDriverService(t *testing.T) {
 	driverService := newSparkDriverService("test-driver-svc")
 
 	f.svcsLister = append(f.svcsLister, driverService)
+	f.addReadyDriverPod(driverService)
 	f.svcsobjects = append(f.svcsobjects, driverService)
 
-	expSparkUISvc := NewSparkUIService(driverService)
-	expIngressRoute := NewSparkUIIngressRoute(expSparkUISvc, hostSuffixTest, driverService)
+	expSparkUISvc := NewSparkUIService(driverService, ExposureOptions{})
+	expIngressRoute := NewSparkUIIngressRoute(expSparkUISvc, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
 	f.expectCreateSparkUIServiceAction(expSparkUISvc)
 	f.expectCreateSparkUIIngressRouteAction(expIngressRoute)
 
 	f.run(getKey(driverService, t))
 }
+
+func TestDryRunMakesNoChanges(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.addReadyDriverPod(driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService)
+	// the managed objects of a driver that is gone.
+	goneDriver := newSparkDriverService("gone-driver-svc")
+	goneUIService := NewSparkUIService(goneDriver, ExposureOptions{})
+	goneRoute := NewSparkUIIngressRoute(goneUIService, goneDriver.Name+hostSuffixTest, goneDriver,
+		testExposureOptions)
+	f.svcsLister = append(f.svcsLister, goneUIService)
+	f.svcsobjects = append(f.svcsobjects, goneUIService)
+	f.irsLister = append(f.irsLister, goneRoute)
+	f.irsobjects = append(f.irsobjects, goneRoute)
+	c, _, _ := f.newController()
+	c.dryRun = true
+
+	createdServices := func() float64 {
+		return actionsTotal.values[actionsTotal.key([]string{"create", "service", "true"})]
+	}
+	before := createdServices()
+	for _, key := range []string{getKey(driverService, t), getKey(goneDriver, t)} {
+		if err := c.syncHandler(key); err != nil {
+			t.Fatalf("error syncing %s: %v", key, err)
+		}
+	}
+	// nothing changed, a resync reports the same actions once.
+	if err := c.syncHandler(getKey(driverService, t)); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+	if created := createdServices() - before; created != 1 {
+		t.Errorf("expected the dry run creation of the ui service to be counted once, got %v", created)
+	}
+
+	var actions []clientgotesting.Action
+	actions = append(actions, f.kubeclient.Actions()...)
+	actions = append(actions, f.contourclient.Actions()...)
+	actions = append(actions, f.dynamicclient.Actions()...)
+	for _, action := range actions {
+		switch action.GetVerb() {
+		case "create", "update", "patch", "delete":
+			t.Errorf("unexpected %s %s in dry run mode", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestPublishesSparkUIEventsOnce(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.addReadyDriverPod(driverService)
+	c, contourI, k8sI := f.newController()
+	events := c.events.watch()
+	key := getKey(driverService, t)
+	// next returns the type of the published event, empty when there is none.
+	next := func() string {
+		select {
+		case event := <-events:
+			return event.Type
+		default:
+			return ""
+		}
+	}
+
+	// a driver that never had a spark ui is not deleted.
+	if err := c.syncHandler("default/never-driver-svc"); err != nil || next() != "" {
+		t.Fatalf("expected no event for an unknown driver, got %v", err)
+	}
+
+	if err := c.syncHandler(key); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+	if event := next(); event != eventAdded {
+		t.Fatalf("expected %s, got %q", eventAdded, event)
+	}
+	// the created objects are now in the informer caches, a resync publishes nothing.
+	services := k8sI.Core().V1().Services().Informer().GetIndexer()
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	services.Add(uiService)
+	contourI.Contour().V1beta1().IngressRoutes().Informer().GetIndexer().Add(NewSparkUIIngressRoute(uiService,
+		driverService.Name+hostSuffixTest, driverService, testExposureOptions))
+	if err := c.syncHandler(key); err != nil || next() != "" {
+		t.Fatalf("expected no event on resync, got %v", err)
+	}
+
+	// the driver is gone, but its ui service can not be deleted yet.
+	services.Delete(driverService)
+	f.kubeclient.PrependReactor("delete", "services", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("api server unavailable")
+	})
+	if err := c.syncHandler(key); err == nil || next() != "" {
+		t.Fatalf("expected the failed cleanup to be retried without an event, got %v", err)
+	}
+	f.kubeclient.ReactionChain = f.kubeclient.ReactionChain[1:]
+	if err := c.syncHandler(key); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+	if event := next(); event != eventDeleted {
+		t.Fatalf("expected %s, got %q", eventDeleted, event)
+	}
+	services.Delete(uiService)
+	if err := c.syncHandler(key); err != nil || next() != "" {
+		t.Fatalf("expected a single %s, got %v", eventDeleted, err)
+	}
+}
+
+func TestSparkUIIngressRouteIsPendingUntilDriverReady(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	// the driver pod is not scheduled yet.
+	driverPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "spark-driver-pod-name",
+			Namespace: metav1.NamespaceDefault,
+			Labels:    driverService.Spec.Selector,
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService)
+	f.podsLister = append(f.podsLister, driverPod)
+
+	f.expectCreateSparkUIServiceAction(NewSparkUIService(driverService, ExposureOptions{}))
+
+	f.run(getKey(driverService, t))
+
+	c, _, _ := f.newController()
+	ui, err := c.newSparkUI(driverService)
+	if err != nil {
+		t.Fatalf("describe spark ui: %v", err)
+	}
+	if ui.Pending == "" {
+		t.Errorf("expected the spark ui to be pending")
+	}
+}
+
+func TestBlocksKillEndpointsOfReadOnlySparkUI(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        metav1.NamespaceDefault,
+			Annotations: map[string]string{readOnlyAnnotation: "true"},
+		},
+	}
+
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.addReadyDriverPod(driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService, ns)
+	f.nsLister = append(f.nsLister, ns)
+
+	opts := testExposureOptions
+	opts.ReadOnly = true
+	expSparkUISvc := NewSparkUIService(driverService, ExposureOptions{})
+	expIngressRoute := NewSparkUIIngressRoute(expSparkUISvc, driverService.Name+hostSuffixTest, driverService, opts)
+	f.expectCreateSparkUIServiceAction(expSparkUISvc)
+	f.expectCreateSparkUIIngressRouteAction(expIngressRoute)
+
+	f.run(getKey(driverService, t))
+
+	// the route is published, with the kill endpoints rewritten away from the spark ui.
+	routes := expIngressRoute.Spec.Routes
+	if len(routes) != 3 || routes[0].Match != "/" || routes[0].PrefixRewrite != "" {
+		t.Fatalf("expected the spark ui route and two kill endpoint routes, got %+v", routes)
+	}
+	for i, kill := range sparkUIKillPaths {
+		if routes[i+1].Match != kill || routes[i+1].PrefixRewrite != readOnlyRewritePath {
+			t.Errorf("expected %s to be rewritten to %s, got %+v", kill, readOnlyRewritePath, routes[i+1])
+		}
+	}
+}
+
+func TestNamespaceAnnotationsEnqueueDrivers(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	other := newSparkDriverService("other-driver-svc")
+	other.Namespace = "other"
+	f.svcsLister = append(f.svcsLister, driverService, other)
+	c, _, _ := f.newController()
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceDefault}}
+	annotated := ns.DeepCopy()
+	annotated.Annotations = map[string]string{readOnlyAnnotation: "true"}
+	handler := c.namespaceEventHandler()
+	handler.OnUpdate(ns, ns.DeepCopy())
+	if c.workqueue.Len() != 0 {
+		t.Fatalf("expected an unchanged namespace to enqueue nothing, got %d", c.workqueue.Len())
+	}
+	handler.OnUpdate(ns, annotated)
+	if c.workqueue.Len() != 1 {
+		t.Fatalf("expected the driver of the namespace to be enqueued, got %d", c.workqueue.Len())
+	}
+	if key, _ := c.workqueue.Get(); key != getKey(driverService, t) {
+		t.Errorf("unexpected key %v", key)
+	}
+}
+
+func TestParseSourceRanges(t *testing.T) {
+	ranges, err := parseSourceRanges(" 10.0.0.0/8, 192.168.1.7 ,,fd00::/8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"10.0.0.0/8", "192.168.1.7/32", "fd00::/8"}
+	if !reflect.DeepEqual(expected, ranges) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+	if _, err := parseSourceRanges("10.0.0.0/33"); err == nil {
+		t.Error("expected error parsing invalid range")
+	}
+}
+
+func TestInvalidSourceRangesAnnotation(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	driverService.Annotations = map[string]string{sourceRangesAnnotation: "not-a-cidr"}
+
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService)
+
+	f.runExpectError(getKey(driverService, t))
+}
+
+func TestInvalidReadOnlyAnnotation(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        metav1.NamespaceDefault,
+			Annotations: map[string]string{readOnlyAnnotation: "yes"},
+		},
+	}
+	// the spark ui published before the annotation was set.
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	ingressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest, driverService,
+		testExposureOptions)
+	f.svcsLister = append(f.svcsLister, driverService, uiService)
+	f.svcsobjects = append(f.svcsobjects, driverService, uiService)
+	f.nsLister = append(f.nsLister, ns)
+	f.irsLister = append(f.irsLister, ingressRoute)
+	f.irsobjects = append(f.irsobjects, ingressRoute)
+	f.addReadyDriverPod(driverService)
+	c, _, _ := f.newController()
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+
+	if err := c.syncHandler(getKey(driverService, t)); err == nil {
+		t.Fatal("expected an invalid read only annotation to be an error")
+	}
+
+	// the spark ui does not stay exposed without the read only mode.
+	svcsactions := filterInformerActions(f.kubeclient.Actions())
+	irsactions := filterInformerActions(f.contourclient.Actions())
+	if len(svcsactions) != 1 || !svcsactions[0].Matches("delete", "services") ||
+		len(irsactions) != 1 || !irsactions[0].Matches("delete", "ingressroutes") {
+		t.Errorf("expected the ui service and the route to be deleted, got %+v and %+v", svcsactions, irsactions)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, corev1.EventTypeWarning+" "+reasonInvalidExposureOptions) {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a warning event")
+	}
+}
+
+func TestWithholdsIngressRouteOfSourceRanges(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	driverService.Annotations = map[string]string{sourceRangesAnnotation: "10.0.0.0/8"}
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	// the allowlist is enforced by the load balancer of the ui service instead of the route.
+	expSparkUISvc := NewSparkUIService(driverService, ExposureOptions{SourceRanges: []string{"10.0.0.0/8"}})
+	if expSparkUISvc.Spec.Type != corev1.ServiceTypeLoadBalancer ||
+		!reflect.DeepEqual(expSparkUISvc.Spec.LoadBalancerSourceRanges, []string{"10.0.0.0/8"}) {
+		t.Fatalf("expected a load balancer restricted to the source ranges, got %+v", expSparkUISvc.Spec)
+	}
+	// a route published before the allowlist was set.
+	ingressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
+
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService)
+	f.irsLister = append(f.irsLister, ingressRoute)
+	f.irsobjects = append(f.irsobjects, ingressRoute)
+	f.addReadyDriverPod(driverService)
+
+	f.expectCreateSparkUIServiceAction(expSparkUISvc)
+	f.expectDeleteIngressRouteAction(ingressRoute.Namespace, ingressRoute.Name)
+
+	f.run(getKey(driverService, t))
+}
+
+func TestEmptySourceRangesAnnotationIsUnset(t *testing.T) {
+	defaults := ExposureOptions{SourceRanges: []string{"10.0.0.0/8"}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceDefault,
+		Annotations: map[string]string{sourceRangesAnnotation: "10.1.0.0/16"}}}
+	driver := newSparkDriverService("test-driver-svc")
+	driver.Annotations = map[string]string{sourceRangesAnnotation: " "}
+
+	opts, err := resolveExposureOptions(defaults, ns, driver)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if !reflect.DeepEqual(opts.SourceRanges, []string{"10.1.0.0/16"}) {
+		t.Errorf("expected the namespace allowlist to be kept, got %v", opts.SourceRanges)
+	}
+	ns.Annotations[sourceRangesAnnotation] = ""
+	if opts, _ = resolveExposureOptions(defaults, ns, driver); !reflect.DeepEqual(opts.SourceRanges, defaults.SourceRanges) {
+		t.Errorf("expected the default allowlist to be kept, got %v", opts.SourceRanges)
+	}
+}
+
+func TestDeletesSparkUIOfMissingDriver(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	ingressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
+
+	f.svcsLister = append(f.svcsLister, uiService)
+	f.svcsobjects = append(f.svcsobjects, uiService)
+	f.irsLister = append(f.irsLister, ingressRoute)
+	f.irsobjects = append(f.irsobjects, ingressRoute)
+
+	f.expectDeleteServiceAction(uiService.Namespace, uiService.Name)
+	f.expectDeleteIngressRouteAction(ingressRoute.Namespace, ingressRoute.Name)
+
+	f.run(getKey(driverService, t))
+}
+
+func TestDeletesSparkUIOfDriverNoLongerDetected(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	ingressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
+
+	f.svcsLister = append(f.svcsLister, driverService, uiService)
+	f.svcsobjects = append(f.svcsobjects, driverService, uiService)
+	f.irsLister = append(f.irsLister, ingressRoute)
+	f.irsobjects = append(f.irsobjects, ingressRoute)
+	c, _, _ := f.newController()
+
+	// the reloaded detection rules exclude the namespace of the driver.
+	cfg := newTestConfig(t)
+	cfg.Detection.ExcludeNamespaces = []string{metav1.NamespaceDefault}
+	c.SetConfig(cfg)
+	if c.workqueue.Len() != 1 {
+		t.Fatalf("expected the driver to be enqueued, got %d keys", c.workqueue.Len())
+	}
+	if err := c.syncHandler(getKey(driverService, t)); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+
+	f.expectDeleteServiceAction(uiService.Namespace, uiService.Name)
+	f.expectDeleteIngressRouteAction(ingressRoute.Namespace, ingressRoute.Name)
+	svcsactions := filterInformerActions(f.kubeclient.Actions())
+	if len(svcsactions) != 1 {
+		t.Fatalf("expected a single service action, got %+v", svcsactions)
+	}
+	checkAction(f.svcsactions[0], svcsactions[0], t)
+	irsactions := filterInformerActions(f.contourclient.Actions())
+	if len(irsactions) != 1 {
+		t.Fatalf("expected a single ingress route action, got %+v", irsactions)
+	}
+	checkAction(f.irsactions[0], irsactions[0], t)
+}
+
+func TestSkipsUnmanagedSparkUIService(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	userService := newSparkDriverService("test-ui-svc")
+
+	f.svcsLister = append(f.svcsLister, driverService, userService)
+	f.svcsobjects = append(f.svcsobjects, driverService, userService)
+
+	f.run(getKey(driverService, t))
+}
+
+func TestKeepsUnmanagedSparkUIOfMissingDriver(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	legacyService := NewSparkUIService(driverService, ExposureOptions{})
+	legacyService.Labels = nil
+	legacyRoute := NewSparkUIIngressRoute(legacyService, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
+	legacyRoute.Labels = nil
+
+	f.svcsLister = append(f.svcsLister, legacyService)
+	f.svcsobjects = append(f.svcsobjects, legacyService)
+	f.irsLister = append(f.irsLister, legacyRoute)
+	f.irsobjects = append(f.irsobjects, legacyRoute)
+
+	f.run(getKey(driverService, t))
+}
+
+func TestWarnsOnceAboutUnmanagedObject(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	c, _, _ := f.newController()
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+	meta := metav1.ObjectMeta{Name: "test-ui-svc", ResourceVersion: "1"}
+
+	c.warnUnmanagedObject(driverService, "service", meta, "service %s exists", meta.Name)
+	c.warnUnmanagedObject(driverService, "service", meta, "service %s exists", meta.Name)
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected a single warning, got %d", len(recorder.Events))
+	}
+	<-recorder.Events
+	meta.ResourceVersion = "2"
+	c.warnUnmanagedObject(driverService, "service", meta, "service %s exists", meta.Name)
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a warning when the object changes, got %d", len(recorder.Events))
+	}
+}
+
+func TestAdoptsSparkUIService(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	legacyService := NewSparkUIService(driverService, ExposureOptions{})
+	legacyService.Labels = nil
+	legacyService.OwnerReferences = driverService.OwnerReferences
+	legacyService.Annotations = map[string]string{adoptAnnotation: "true"}
+
+	f.svcsLister = append(f.svcsLister, driverService, legacyService)
+	f.addReadyDriverPod(driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService, legacyService)
+
+	expSparkUISvc := NewSparkUIService(driverService, ExposureOptions{})
+	expSparkUISvc.Annotations = map[string]string{}
+	expIngressRoute := NewSparkUIIngressRoute(expSparkUISvc, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
+	f.svcsactions = append(f.svcsactions, clientgotesting.NewUpdateAction(schema.
+		GroupVersionResource{Resource: "services"}, expSparkUISvc.Namespace, expSparkUISvc))
+	f.expectCreateSparkUIIngressRouteAction(expIngressRoute)
+
+	f.run(getKey(driverService, t))
+}
+
+func TestUpdatesDriftedIngressRoute(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	// a route created with the request timeout of a previous config.
+	ingressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest, driverService,
+		ExposureOptions{RequestTimeout: "5s"})
+
+	f.svcsLister = append(f.svcsLister, driverService, uiService)
+	f.svcsobjects = append(f.svcsobjects, driverService, uiService)
+	f.irsLister = append(f.irsLister, ingressRoute)
+	f.irsobjects = append(f.irsobjects, ingressRoute)
+
+	expIngressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest, driverService,
+		testExposureOptions)
+	expIngressRoute.Annotations = map[string]string{}
+	f.expectUpdateSparkUIIngressRouteAction(expIngressRoute)
+
+	f.run(getKey(driverService, t))
+}
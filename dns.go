@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// dnsModeAnnotations annotates the ingress routes for the external-dns contour-ingressroute
+	// source.
+	dnsModeAnnotations = "annotations"
+	// dnsModeDNSEndpoint creates a DNSEndpoint per driver for the external-dns crd source.
+	dnsModeDNSEndpoint = "dnsendpoint"
+
+	externalDNSTargetAnnotation = "external-dns.alpha.kubernetes.io/target"
+	externalDNSTTLAnnotation    = "external-dns.alpha.kubernetes.io/ttl"
+
+	sparkUIDNSEndpointSuffix = "-ui-dns"
+)
+
+// dnsEndpointResource is the DNSEndpoint crd of external-dns.
+var dnsEndpointResource = schema.GroupVersionResource{
+	Group: "externaldns.k8s.io", Version: "v1alpha1", Resource: "dnsendpoints",
+}
+
+// DNSEndpoint is the subset of the external-dns DNSEndpoint the controller writes.
+type DNSEndpoint struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DNSEndpointSpec `json:"spec"`
+}
+
+// DNSEndpointSpec are the records external-dns publishes.
+type DNSEndpointSpec struct {
+	Endpoints []DNSRecord `json:"endpoints"`
+}
+
+// DNSRecord is a record of a DNSEndpoint, RecordTTL is the external-dns default when zero.
+type DNSRecord struct {
+	DNSName    string   `json:"dnsName"`
+	Targets    []string `json:"targets"`
+	RecordType string   `json:"recordType"`
+	RecordTTL  int64    `json:"recordTTL,omitempty"`
+}
+
+// getSparkUIDNSEndpointName is the name of the DNSEndpoint of a driver service.
+func getSparkUIDNSEndpointName(driverName string) string {
+	return strings.Replace(driverName, driverServiceSuffix, sparkUIDNSEndpointSuffix, 1)
+}
+
+// NewSparkUIDNSEndpoint builds the DNSEndpoint pointing the host of a spark ui at the load
+// balancer targets, an A record for addresses and a CNAME for a load balancer host name. It is
+// controlled by the driver service, so the record is removed with the driver.
+func NewSparkUIDNSEndpoint(driver *corev1.Service, host string, targets []string, ttl int64) *DNSEndpoint {
+	record := DNSRecord{DNSName: host, Targets: targets, RecordType: "A", RecordTTL: ttl}
+	if net.ParseIP(targets[0]) == nil {
+		// a name can only have one CNAME.
+		record.RecordType = "CNAME"
+		record.Targets = targets[:1]
+	}
+	return &DNSEndpoint{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: dnsEndpointResource.GroupVersion().String(),
+			Kind:       "DNSEndpoint",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSparkUIDNSEndpointName(driver.Name),
+			Namespace: driver.Namespace,
+			Labels:    managedLabels(driver.Name),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(driver, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Spec: DNSEndpointSpec{Endpoints: []DNSRecord{record}},
+	}
+}
+
+// dnsEndpointFromObject converts an object of the dynamic client.
+func dnsEndpointFromObject(u *unstructured.Unstructured) (*DNSEndpoint, error) {
+	endpoint := &DNSEndpoint{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, endpoint); err != nil {
+		return nil, fmt.Errorf("dnsendpoint %s/%s: %s", u.GetNamespace(), u.GetName(), err.Error())
+	}
+	return endpoint, nil
+}
+
+// loadBalancerTargets are the addresses, or host names, of the load balancer of the envoy
+// service the spark ui records point at.
+func (c *Controller) loadBalancerTargets() ([]string, error) {
+	lb := c.getConfig().DNS.LoadBalancer
+	namespace, name, err := cache.SplitMetaNamespaceKey(lb)
+	if err != nil {
+		return nil, err
+	}
+	service, err := c.servicesLister.Services(namespace).Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("load balancer service %s: %s", lb, err.Error())
+	}
+	var targets []string
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			targets = append(targets, ingress.IP)
+		} else if ingress.Hostname != "" {
+			targets = append(targets, ingress.Hostname)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("load balancer service %s has no address yet", lb)
+	}
+	return targets, nil
+}
+
+// ensureSparkUIDNSEndpoint creates or updates the DNSEndpoint of a driver in the dnsendpoint dns
+// mode, see deleteSparkUIDNSEndpoints for when it is off. Without a load balancer address nothing
+// is published, the drivers are enqueued again when it gets one. The DNSEndpoints are read from
+// the api server rather than an informer, so the external-dns crd is only needed when the mode is
+// enabled.
+func (c *Controller) ensureSparkUIDNSEndpoint(driver *corev1.Service, opts ExposureOptions) error {
+	cfg := c.getConfig()
+	if cfg.DNS.Mode != dnsModeDNSEndpoint {
+		return nil
+	}
+	targets, err := c.loadBalancerTargets()
+	if err != nil {
+		klog.Warningf("Not publishing the dns record of %s/%s: %s", driver.Namespace, driver.Name, err.Error())
+		return nil
+	}
+	desired := NewSparkUIDNSEndpoint(driver, cfg.sparkUIHost(driver, opts.HostSuffix), targets, cfg.DNS.TTL)
+	u, err := c.dynamicclientset.Resource(dnsEndpointResource).Namespace(driver.Namespace).Get(desired.Name,
+		metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		klog.Infof("spark ui dnsendpoint with name: %s is not found, now create one ...", desired.Name)
+		return c.createDNSEndpoint(desired)
+	}
+	existing, err := dnsEndpointFromObject(u)
+	if err != nil {
+		return err
+	}
+	if !isManagedBy(existing.ObjectMeta, driver.Name) {
+		c.warnUnmanagedObject(driver, "dnsendpoint", existing.ObjectMeta,
+			"dnsendpoint %s exists and is not managed by %s", desired.Name, managedByValue)
+		return nil
+	}
+	if equality.Semantic.DeepEqual(existing.Spec, desired.Spec) {
+		return nil
+	}
+	klog.Infof("spark ui dnsendpoint with name: %s differs from the desired one, updating it", desired.Name)
+	updated := *existing
+	updated.Spec = desired.Spec
+	return c.updateDNSEndpoint(existing, &updated)
+}
+
+// deleteSparkUIDNSEndpoints deletes the managed DNSEndpoints when the dnsendpoint dns mode is off,
+// at startup and when a config reload turns it off, so external-dns stops publishing the records.
+// Without the external-dns crd there is nothing to delete.
+func (c *Controller) deleteSparkUIDNSEndpoints() {
+	if c.getConfig().DNS.Mode == dnsModeDNSEndpoint {
+		return
+	}
+	list, err := c.dynamicclientset.Resource(dnsEndpointResource).Namespace(metav1.NamespaceAll).List(
+		metav1.ListOptions{LabelSelector: managedSelector().String()})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("List managed dnsendpoints failed: %s", err.Error())
+		}
+		return
+	}
+	for _, u := range list.Items {
+		if u.GetLabels()[driverServiceLabel] == "" {
+			continue
+		}
+		klog.Infof("dnsendpoints are disabled, deleting dnsendpoint %s/%s", u.GetNamespace(), u.GetName())
+		err := c.deleteDNSEndpoint(u.GetNamespace(), u.GetName())
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Delete dnsendpoint: %s/%s failed: %s", u.GetNamespace(), u.GetName(), err.Error())
+		}
+	}
+}
+
+// loadBalancerEventHandler re-enqueues every driver when the address of the load balancer the
+// dns records point at changes.
+func (c *Controller) loadBalancerEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldService, ok := oldObj.(*corev1.Service)
+			newService, ok2 := newObj.(*corev1.Service)
+			cfg := c.getConfig()
+			if !ok || !ok2 || cfg.DNS.Mode == "" || newService.Namespace+"/"+newService.Name != cfg.DNS.LoadBalancer ||
+				reflect.DeepEqual(oldService.Status.LoadBalancer, newService.Status.LoadBalancer) {
+				return
+			}
+			klog.Infof("Load balancer %s changed, updating the dns records", cfg.DNS.LoadBalancer)
+			services, err := c.servicesLister.List(labels.Everything())
+			if err != nil {
+				klog.Errorf("List services failed: %s", err.Error())
+				return
+			}
+			for _, svc := range services {
+				if cfg.isSparkDriverService(svc) {
+					c.workqueue.Add(svc.Namespace + "/" + svc.Name)
+				}
+			}
+		},
+	}
+}
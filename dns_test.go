@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+// newLoadBalancerService returns the envoy service of the dns tests, with a load balancer address.
+func newLoadBalancerService(ingress corev1.LoadBalancerIngress) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "envoy", Namespace: "projectcontour"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{ingress}},
+		},
+	}
+}
+
+func TestNewSparkUIDNSEndpoint(t *testing.T) {
+	driver := newSparkDriverService("test-driver-svc")
+	endpoint := NewSparkUIDNSEndpoint(driver, "test.spark-ui.example.com", []string{"10.0.0.1", "10.0.0.2"}, 60)
+	record := endpoint.Spec.Endpoints[0]
+	if endpoint.Name != "test-ui-dns" || record.RecordType != "A" || len(record.Targets) != 2 || record.RecordTTL != 60 {
+		t.Errorf("unexpected dnsendpoint %s %+v", endpoint.Name, record)
+	}
+	endpoint = NewSparkUIDNSEndpoint(driver, "test.spark-ui.example.com", []string{"lb-1.elb.amazonaws.com",
+		"lb-2.elb.amazonaws.com"}, 0)
+	record = endpoint.Spec.Endpoints[0]
+	if record.RecordType != "CNAME" || len(record.Targets) != 1 || record.Targets[0] != "lb-1.elb.amazonaws.com" {
+		t.Errorf("expected a single CNAME target, got %+v", record)
+	}
+}
+
+func TestEnsureSparkUIDNSEndpoint(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService, newLoadBalancerService(corev1.LoadBalancerIngress{IP: "203.0.113.10"}))
+	c, _, _ := f.newController()
+	cfg := newTestConfig(t)
+	if err := cfg.merge([]byte("dns:\n  mode: dnsendpoint\n  loadBalancer: projectcontour/envoy\n  ttl: 60\n")); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	c.config = cfg
+
+	if err := c.ensureSparkUIDNSEndpoint(driverService, testExposureOptions); err != nil {
+		t.Fatalf("ensure dnsendpoint: %v", err)
+	}
+	u, err := f.dynamicclient.Resource(dnsEndpointResource).Namespace(metav1.NamespaceDefault).Get(
+		"test-ui-dns", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get dnsendpoint: %v", err)
+	}
+	endpoint, err := dnsEndpointFromObject(u)
+	if err != nil {
+		t.Fatalf("convert dnsendpoint: %v", err)
+	}
+	record := endpoint.Spec.Endpoints[0]
+	if record.DNSName != driverService.Name+hostSuffixTest || record.RecordType != "A" ||
+		len(record.Targets) != 1 || record.Targets[0] != "203.0.113.10" || record.RecordTTL != 60 {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if !isManagedBy(endpoint.ObjectMeta, driverService.Name) {
+		t.Errorf("expected the dnsendpoint to be managed, got labels %v", endpoint.Labels)
+	}
+}
+
+func TestDeletesDNSEndpointsWhenDisabled(t *testing.T) {
+	f := newFixture(t)
+	c, _, _ := f.newController()
+	cfg := newTestConfig(t)
+	if err := cfg.merge([]byte("dns:\n  mode: dnsendpoint\n  loadBalancer: projectcontour/envoy\n")); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	c.config = cfg
+	managed, err := runtime.DefaultUnstructuredConverter.ToUnstructured(NewSparkUIDNSEndpoint(
+		newSparkDriverService("test-driver-svc"), "test.spark-ui.example.com", []string{"10.0.0.1"}, 0))
+	if err != nil {
+		t.Fatalf("convert dnsendpoint: %v", err)
+	}
+	f.dynamicclient.PrependReactor("list", "dnsendpoints", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: managed}}}, nil
+	})
+
+	// the mode is still on.
+	c.deleteSparkUIDNSEndpoints()
+	if actions := f.dynamicclient.Actions(); len(actions) != 0 {
+		t.Fatalf("expected no action, got %+v", actions)
+	}
+
+	c.SetConfig(newTestConfig(t))
+	var deleted []string
+	for _, action := range f.dynamicclient.Actions() {
+		if action.GetVerb() == "delete" {
+			deleted = append(deleted, action.(clientgotesting.DeleteAction).GetName())
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "test-ui-dns" {
+		t.Errorf("expected the managed dnsendpoint to be deleted, got %v", deleted)
+	}
+}
+
+func TestSparkUIIngressRouteExternalDNSAnnotations(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService,
+		newLoadBalancerService(corev1.LoadBalancerIngress{Hostname: "lb.elb.amazonaws.com"}))
+	c, _, _ := f.newController()
+	cfg := newTestConfig(t)
+	if err := cfg.merge([]byte("dns:\n  mode: annotations\n  loadBalancer: projectcontour/envoy\n  ttl: 60\n")); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	c.config = cfg
+
+	opts, err := c.exposureOptions(driverService)
+	if err != nil {
+		t.Fatalf("exposure options: %v", err)
+	}
+	uiService := NewSparkUIService(driverService, opts)
+	route := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest, driverService, opts)
+	if route.Annotations[externalDNSTargetAnnotation] != "lb.elb.amazonaws.com" ||
+		route.Annotations[externalDNSTTLAnnotation] != "60" {
+		t.Errorf("unexpected annotations %v", route.Annotations)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+)
+
+// doctorCheck is one link of the chain from the ui link to the spark driver.
+type doctorCheck struct {
+	name   string
+	passed bool
+	// skipped checks depend on a check that failed.
+	skipped bool
+	detail  string
+	hint    string
+}
+
+// runDoctor implements the doctor command, it diagnoses why the spark ui of a driver can not be
+// reached and exits with a non zero status when a check fails:
+//
+//	spark-ui-controller-envoy [flags] doctor <namespace>/<driver service>
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: doctor <namespace>/<driver service>")
+		return 2
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(fs.Arg(0))
+	if err != nil || namespace == "" {
+		fmt.Fprintf(os.Stderr, "invalid key %q, expected <namespace>/<driver service>\n", fs.Arg(0))
+		return 2
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	controller, err := startController(stopCh)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	checks := controller.diagnose(namespace, name)
+	if !printDoctorChecks(os.Stdout, checks) {
+		return 1
+	}
+	return 0
+}
+
+// printDoctorChecks writes the checklist to w and reports whether every check passed.
+func printDoctorChecks(w io.Writer, checks []doctorCheck) bool {
+	ok := true
+	for _, check := range checks {
+		status := "PASS"
+		switch {
+		case check.skipped:
+			status = "SKIP"
+		case !check.passed:
+			status = "FAIL"
+			ok = false
+		}
+		fmt.Fprintf(w, "[%s] %s", status, check.name)
+		if check.detail != "" {
+			fmt.Fprintf(w, ": %s", check.detail)
+		}
+		fmt.Fprintln(w)
+		if !check.passed && !check.skipped && check.hint != "" {
+			fmt.Fprintf(w, "       hint: %s\n", check.hint)
+		}
+	}
+	return ok
+}
+
+// diagnose walks the chain a request for the spark ui of a driver service follows, from the
+// driver pod to the ingress route, and returns a check per link. Once a link is broken the
+// checks depending on it are skipped.
+func (c *Controller) diagnose(namespace, name string) []doctorCheck {
+	var checks []doctorCheck
+	pass := func(name, detail string) {
+		checks = append(checks, doctorCheck{name: name, passed: true, detail: detail})
+	}
+	fail := func(name, detail, hint string) {
+		checks = append(checks, doctorCheck{name: name, detail: detail, hint: hint})
+	}
+	skip := func(names ...string) []doctorCheck {
+		for _, name := range names {
+			checks = append(checks, doctorCheck{name: name, skipped: true})
+		}
+		return checks
+	}
+	const (
+		checkDriver    = "driver service is a spark driver service"
+		checkSelector  = "driver service selects a pod"
+		checkReady     = "driver pod is Ready"
+		checkEndpoints = "driver service has endpoints"
+		checkUIPort    = "driver pod declares the ui port"
+		checkUIService = "ui service exists and matches"
+		checkRoute     = "ingress route exists and matches"
+		checkStatus    = "ingress route is valid"
+		checkFqdn      = "ingress route fqdn is valid"
+	)
+
+	driver, err := c.servicesLister.Services(namespace).Get(name)
+	if err != nil {
+		fail(checkDriver, err.Error(), "check the name, spark names the driver service <app>"+driverServiceSuffix)
+		return skip(checkSelector, checkReady, checkEndpoints, checkUIPort, checkUIService, checkRoute,
+			checkStatus, checkFqdn)
+	}
+	if !c.isSparkDriverService(driver) {
+		fail(checkDriver, fmt.Sprintf("the name must end with %s, the selector must contain %s and the "+
+			"namespace must be watched", driverServiceSuffix, labels.SelectorFromSet(c.getConfig().Detection.Selector)),
+			"the controller only exposes the services created by spark for its drivers, check the detection "+
+				"rules of the config")
+		return skip(checkSelector, checkReady, checkEndpoints, checkUIPort, checkUIService, checkRoute,
+			checkStatus, checkFqdn)
+	}
+	pass(checkDriver, "")
+
+	pods, err := c.podsLister.Pods(namespace).List(labels.SelectorFromSet(driver.Spec.Selector))
+	switch {
+	case err != nil:
+		fail(checkSelector, err.Error(), "")
+	case len(pods) == 0:
+		fail(checkSelector, fmt.Sprintf("no pod matches %s", labels.SelectorFromSet(driver.Spec.Selector)),
+			"the driver pod was deleted, or its labels were changed after submission")
+	default:
+		pass(checkSelector, "pod "+pods[0].Name)
+	}
+	var pod *corev1.Pod
+	if len(pods) > 0 {
+		pod = pods[0]
+	}
+	switch {
+	case pod == nil:
+		skip(checkReady)
+	case sparkDriverFinished(pod):
+		fail(checkReady, "the driver has finished", "the spark ui stops with the application, "+
+			"use the history server or enable -history_redirect")
+	case !podReady(pod):
+		fail(checkReady, "pod is "+string(pod.Status.Phase), "kubectl describe pod -n "+namespace+" "+
+			pod.Name+" shows why the driver is not ready")
+	default:
+		pass(checkReady, "")
+	}
+
+	endpoints, err := c.endpointsLister.Endpoints(namespace).Get(name)
+	switch {
+	case err != nil:
+		fail(checkEndpoints, err.Error(), "")
+	case !hasReadyUIAddress(endpoints):
+		fail(checkEndpoints, "no ready address serving the ui port", "the driver pod is not ready or the "+
+			"selector does not match it")
+	default:
+		pass(checkEndpoints, "")
+	}
+
+	switch {
+	case pod == nil:
+		skip(checkUIPort)
+	case !podDeclaresPort(pod, sparkUIPort):
+		fail(checkUIPort, fmt.Sprintf("no container port %d", sparkUIPort), fmt.Sprintf("the controller "+
+			"expects the ui on port %d, check spark.ui.port and that spark.ui.enabled is not false", sparkUIPort))
+	default:
+		pass(checkUIPort, "")
+	}
+
+	opts, err := c.exposureOptions(driver)
+	if err != nil {
+		fail(checkUIService, err.Error(), "fix the "+sourceRangesAnnotation+" or "+readOnlyAnnotation+
+			" annotation of the driver service or its namespace")
+		return skip(checkRoute, checkStatus, checkFqdn)
+	}
+	desiredService := NewSparkUIService(driver, opts)
+	uiService, err := c.servicesLister.Services(namespace).Get(desiredService.Name)
+	if err != nil {
+		hint := "kubectl get events -n " + namespace + " --field-selector involvedObject.name=" + name +
+			" shows why the controller did not create it"
+		if !errors.IsNotFound(err) {
+			hint = ""
+		}
+		fail(checkUIService, err.Error(), hint)
+		return skip(checkRoute, checkStatus, checkFqdn)
+	}
+	if diff := serviceDrift(uiService, desiredService); diff != "" {
+		fail(checkUIService, diff, "delete the service, or annotate it with "+adoptAnnotation+
+			"=true when it is not managed, to let the controller recreate it")
+	} else {
+		pass(checkUIService, "")
+	}
+
+	if c.ingressRoutesDisabled {
+		fail(checkRoute, "contour's IngressRoute crd is not installed", "open the spark ui through the "+
+			"built-in proxy, or install contour and restart the controller")
+		return skip(checkStatus, checkFqdn)
+	}
+	if reason := unenforceableOptions(opts); reason != "" {
+		hint := "open the spark ui through the built-in proxy, which enforces the exposure options"
+		if len(opts.SourceRanges) > 0 {
+			hint = "kubectl get service -n " + namespace + " " + uiService.Name + " shows the address of " +
+				"its load balancer, or open the spark ui through the built-in proxy"
+		}
+		fail(checkRoute, "the route is withheld, "+reason, hint)
+		return skip(checkStatus, checkFqdn)
+	}
+	desiredRoute := NewSparkUIIngressRoute(uiService, c.getConfig().sparkUIHost(driver, opts.HostSuffix), driver, opts)
+	route, err := c.ingressRoutesLister.IngressRoutes(namespace).Get(desiredRoute.Name)
+	if err != nil {
+		fail(checkRoute, err.Error(), "kubectl get events -n "+namespace+" --field-selector involvedObject.name="+
+			name+" shows why the controller did not create it")
+		return skip(checkStatus, checkFqdn)
+	}
+	switch {
+	case !isManagedBy(route.ObjectMeta, driver.Name):
+		fail(checkRoute, "the route is not managed by "+managedByValue, "annotate it with "+adoptAnnotation+
+			"=true to let the controller adopt it")
+	case route.Annotations[historyAppIDAnnotation] != "":
+		pass(checkRoute, "redirecting to the history server")
+	case !equality.Semantic.DeepEqual(route.Spec, desiredRoute.Spec):
+		fail(checkRoute, "the route spec differs from the desired one", "delete the route to let the "+
+			"controller recreate it, `-dry_run reconcile` shows the difference")
+	default:
+		pass(checkRoute, "")
+	}
+
+	if route.Status.CurrentStatus == "valid" {
+		pass(checkStatus, "")
+	} else {
+		status := route.Status.CurrentStatus
+		if status == "" {
+			status = "not processed by contour"
+		}
+		fail(checkStatus, strings.TrimSpace(status+" "+route.Status.Description),
+			"check that contour is running and that no other route claims the same fqdn")
+	}
+
+	fqdn := ""
+	if route.Spec.VirtualHost != nil {
+		fqdn = route.Spec.VirtualHost.Fqdn
+	}
+	if errs := validateFqdn(fqdn); len(errs) > 0 {
+		fail(checkFqdn, fqdn+": "+strings.Join(errs, ", "), "the hostname template and suffix must give a valid "+
+			"host name, shorten the template or the application name")
+	} else {
+		pass(checkFqdn, fqdn)
+	}
+	return checks
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func podDeclaresPort(pod *corev1.Pod, port int32) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			if p.ContainerPort == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serviceDrift describes how a ui service differs from the desired one, ignoring the fields
+// defaulted by the api server.
+func serviceDrift(current, desired *corev1.Service) string {
+	var drift []string
+	if !isManagedBy(current.ObjectMeta, desired.Labels[driverServiceLabel]) {
+		drift = append(drift, "not managed by "+managedByValue)
+	}
+	if !equality.Semantic.DeepEqual(current.Spec.Selector, desired.Spec.Selector) {
+		drift = append(drift, "selector differs")
+	}
+	if current.Spec.Type != desired.Spec.Type {
+		drift = append(drift, fmt.Sprintf("type is %s instead of %s", current.Spec.Type, desired.Spec.Type))
+	}
+	if !equality.Semantic.DeepEqual(current.Spec.LoadBalancerSourceRanges, desired.Spec.LoadBalancerSourceRanges) {
+		drift = append(drift, "load balancer source ranges differ")
+	}
+	found := false
+	for _, p := range current.Spec.Ports {
+		if p.Name == sparkUIPortName && p.Port == sparkUIPort && p.TargetPort.IntValue() == sparkUIPort {
+			found = true
+		}
+	}
+	if !found {
+		drift = append(drift, fmt.Sprintf("no port %s %d targeting %d", sparkUIPortName, sparkUIPort, sparkUIPort))
+	}
+	return strings.Join(drift, ", ")
+}
+
+// validateFqdn checks a host name, including the length of every label which
+// IsDNS1123Subdomain does not.
+func validateFqdn(fqdn string) []string {
+	if fqdn == "" {
+		return []string{"empty"}
+	}
+	errs := validation.IsDNS1123Subdomain(fqdn)
+	for _, label := range strings.Split(fqdn, ".") {
+		if len(label) > validation.DNS1123LabelMaxLength {
+			errs = append(errs, fmt.Sprintf("label %s is longer than %d characters", label,
+				validation.DNS1123LabelMaxLength))
+		}
+	}
+	return errs
+}
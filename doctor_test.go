@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// doctorObjects are the objects of a driver whose spark ui is reachable.
+type doctorObjects struct {
+	driver    *corev1.Service
+	uiService *corev1.Service
+	pod       *corev1.Pod
+	endpoints *corev1.Endpoints
+	route     *contourv1.IngressRoute
+}
+
+func newDoctorObjects(t *testing.T, name string, opts ExposureOptions) *doctorObjects {
+	driver := newSparkDriverService(name)
+	if len(opts.SourceRanges) > 0 {
+		driver.Annotations = map[string]string{sourceRangesAnnotation: strings.Join(opts.SourceRanges, ",")}
+	}
+	uiService := NewSparkUIService(driver, opts)
+	route := NewSparkUIIngressRoute(uiService, newTestConfig(t).sparkUIHost(driver, hostSuffixTest), driver,
+		testExposureOptions)
+	route.Status.CurrentStatus = "valid"
+	return &doctorObjects{
+		driver:    driver,
+		uiService: uiService,
+		pod: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "spark-driver-pod-name",
+				Namespace: metav1.NamespaceDefault,
+				Labels:    driver.Spec.Selector,
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "spark-kubernetes-driver",
+				Ports: []corev1.ContainerPort{{Name: sparkUIPortName, ContainerPort: sparkUIPort}},
+			}}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		},
+		endpoints: &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: driver.Name, Namespace: metav1.NamespaceDefault},
+			Subsets: []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+				Ports: []corev1.EndpointPort{{Name: sparkUIPortName, Port: sparkUIPort}}}},
+		},
+		route: route,
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	const (
+		checkDriver    = "driver service is a spark driver service"
+		checkSelector  = "driver service selects a pod"
+		checkReady     = "driver pod is Ready"
+		checkEndpoints = "driver service has endpoints"
+		checkUIPort    = "driver pod declares the ui port"
+		checkUIService = "ui service exists and matches"
+		checkRoute     = "ingress route exists and matches"
+		checkStatus    = "ingress route is valid"
+		checkFqdn      = "ingress route fqdn is valid"
+	)
+	tests := []struct {
+		name    string
+		driver  string
+		opts    ExposureOptions
+		mutate  func(objs *doctorObjects)
+		failed  []string
+		skipped []string
+	}{
+		{
+			name: "reachable",
+		},
+		{
+			name:   "missing driver service",
+			mutate: func(objs *doctorObjects) { objs.driver = nil },
+			failed: []string{checkDriver},
+			skipped: []string{checkSelector, checkReady, checkEndpoints, checkUIPort, checkUIService, checkRoute,
+				checkStatus, checkFqdn},
+		},
+		{
+			name:    "missing pod",
+			mutate:  func(objs *doctorObjects) { objs.pod = nil },
+			failed:  []string{checkSelector},
+			skipped: []string{checkReady, checkUIPort},
+		},
+		{
+			name: "pod not ready",
+			mutate: func(objs *doctorObjects) {
+				objs.pod.Status.Phase = corev1.PodPending
+				objs.pod.Status.Conditions = nil
+			},
+			failed: []string{checkReady},
+		},
+		{
+			name:   "no endpoints",
+			mutate: func(objs *doctorObjects) { objs.endpoints = nil },
+			failed: []string{checkEndpoints},
+		},
+		{
+			name: "no ready address on the ui port",
+			mutate: func(objs *doctorObjects) {
+				objs.endpoints.Subsets[0].Ports[0] = corev1.EndpointPort{Name: "driver-rpc-port", Port: 7078}
+			},
+			failed: []string{checkEndpoints},
+		},
+		{
+			name:   "missing ui port",
+			mutate: func(objs *doctorObjects) { objs.pod.Spec.Containers[0].Ports = nil },
+			failed: []string{checkUIPort},
+		},
+		{
+			name:    "missing ui service",
+			mutate:  func(objs *doctorObjects) { objs.uiService = nil },
+			failed:  []string{checkUIService},
+			skipped: []string{checkRoute, checkStatus, checkFqdn},
+		},
+		{
+			name:    "withheld route",
+			opts:    ExposureOptions{SourceRanges: []string{"10.0.0.0/8"}},
+			mutate:  func(objs *doctorObjects) { objs.route = nil },
+			failed:  []string{checkRoute},
+			skipped: []string{checkStatus, checkFqdn},
+		},
+		{
+			name:    "missing route",
+			mutate:  func(objs *doctorObjects) { objs.route = nil },
+			failed:  []string{checkRoute},
+			skipped: []string{checkStatus, checkFqdn},
+		},
+		{
+			name:   "unmanaged route",
+			mutate: func(objs *doctorObjects) { objs.route.Labels = nil },
+			failed: []string{checkRoute},
+		},
+		{
+			name:   "drifted route",
+			mutate: func(objs *doctorObjects) { objs.route.Spec.VirtualHost.Fqdn = "other-driver-svc.spark-ui.test" },
+			failed: []string{checkRoute},
+		},
+		{
+			name: "invalid status",
+			mutate: func(objs *doctorObjects) {
+				objs.route.Status.CurrentStatus = "invalid"
+				objs.route.Status.Description = "fqdn is already claimed"
+			},
+			failed: []string{checkStatus},
+		},
+		{
+			name:   "not processed by contour",
+			mutate: func(objs *doctorObjects) { objs.route.Status.CurrentStatus = "" },
+			failed: []string{checkStatus},
+		},
+		{
+			// the host is the driver service name, longer than a dns label.
+			name:   "bad fqdn",
+			driver: strings.Repeat("a", 60) + driverServiceSuffix,
+			failed: []string{checkFqdn},
+		},
+	}
+	for _, test := range tests {
+		name := test.driver
+		if name == "" {
+			name = "test-driver-svc"
+		}
+		objs := newDoctorObjects(t, name, test.opts)
+		if test.mutate != nil {
+			test.mutate(objs)
+		}
+		f := newFixture(t)
+		if objs.driver != nil {
+			f.svcsLister = append(f.svcsLister, objs.driver)
+		}
+		if objs.uiService != nil {
+			f.svcsLister = append(f.svcsLister, objs.uiService)
+		}
+		if objs.pod != nil {
+			f.podsLister = append(f.podsLister, objs.pod)
+		}
+		if objs.endpoints != nil {
+			f.epsLister = append(f.epsLister, objs.endpoints)
+		}
+		if objs.route != nil {
+			f.irsLister = append(f.irsLister, objs.route)
+		}
+		c, _, _ := f.newController()
+
+		var failed, skipped []string
+		checks := c.diagnose(metav1.NamespaceDefault, name)
+		for _, check := range checks {
+			switch {
+			case check.skipped:
+				skipped = append(skipped, check.name)
+			case !check.passed:
+				failed = append(failed, check.name)
+			}
+		}
+		if len(checks) != 9 {
+			t.Errorf("%s: expected 9 checks, got %d", test.name, len(checks))
+		}
+		if !reflect.DeepEqual(failed, test.failed) {
+			t.Errorf("%s: expected failed checks %v, got %v", test.name, test.failed, failed)
+		}
+		if !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("%s: expected skipped checks %v, got %v", test.name, test.skipped, skipped)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// a SparkUIEndpoint is created for every driver service, named after the application, so that
+// kubectl get sparkuiendpoints tells where a spark ui is and whether it works.
+var sparkUIEndpointResource = schema.GroupVersionResource{
+	Group: "spark-ui.ushareit.com", Version: "v1alpha1", Resource: "sparkuiendpoints",
+}
+
+const (
+	// endpointStatusInterval is how often the endpoint statuses are refreshed, the route status
+	// and the ui service endpoints change without the driver service being synced.
+	endpointStatusInterval = 30 * time.Second
+
+	conditionRouteAccepted     = "RouteAccepted"
+	conditionUpstreamReachable = "UpstreamReachable"
+	conditionReady             = "Ready"
+)
+
+// SparkUIEndpoint is where the spark ui of a driver is exposed and whether it can be reached.
+type SparkUIEndpoint struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SparkUIEndpointSpec   `json:"spec"`
+	Status SparkUIEndpointStatus `json:"status,omitempty"`
+}
+
+// SparkUIEndpointSpec is the driver the endpoint was created for, it is only informative.
+type SparkUIEndpointSpec struct {
+	DriverService string `json:"driverService"`
+	AppID         string `json:"appId,omitempty"`
+}
+
+// SparkUIEndpointStatus is refreshed by the controller.
+type SparkUIEndpointStatus struct {
+	URL     string `json:"url"`
+	Backend string `json:"backend"`
+	Route   string `json:"route,omitempty"`
+	// RouteAccepted is true when contour reports the route valid.
+	RouteAccepted bool `json:"routeAccepted"`
+	// UpstreamReachable is true when the ui service has a ready endpoint that answers the probes.
+	UpstreamReachable bool                       `json:"upstreamReachable"`
+	Health            string                     `json:"health"`
+	Conditions        []SparkUIEndpointCondition `json:"conditions,omitempty"`
+}
+
+// SparkUIEndpointCondition follows the conditions of the core types, LastTransitionTime is
+// when Status last changed.
+type SparkUIEndpointCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// getSparkUIEndpointName is the name of the endpoint of a driver service, the application name.
+func getSparkUIEndpointName(driverName string) string {
+	return strings.TrimSuffix(driverName, driverServiceSuffix)
+}
+
+// NewSparkUIEndpoint builds the endpoint of a driver service without status, it is controlled by
+// the driver service so it is garbage collected with it.
+func NewSparkUIEndpoint(driver *corev1.Service) *SparkUIEndpoint {
+	return &SparkUIEndpoint{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: sparkUIEndpointResource.GroupVersion().String(),
+			Kind:       "SparkUIEndpoint",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSparkUIEndpointName(driver.Name),
+			Namespace: driver.Namespace,
+			Labels:    managedLabels(driver.Name),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(driver, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Spec: SparkUIEndpointSpec{
+			DriverService: driver.Name,
+			AppID:         driver.Spec.Selector[sparkAppSelectorLabel],
+		},
+	}
+}
+
+// endpointFromObject converts an object of the dynamic informer or client.
+func endpointFromObject(obj interface{}) (*SparkUIEndpoint, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	endpoint := &SparkUIEndpoint{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, endpoint); err != nil {
+		return nil, fmt.Errorf("endpoint %s/%s: %s", u.GetNamespace(), u.GetName(), err.Error())
+	}
+	return endpoint, nil
+}
+
+// setCondition replaces the condition of the same type, keeping its transition time when its
+// status did not change.
+func setCondition(conditions []SparkUIEndpointCondition, condition SparkUIEndpointCondition,
+	now metav1.Time) []SparkUIEndpointCondition {
+	condition.LastTransitionTime = now
+	for i, existing := range conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		conditions[i] = condition
+		return conditions
+	}
+	return append(conditions, condition)
+}
+
+func conditionStatus(ok bool) corev1.ConditionStatus {
+	if ok {
+		return corev1.ConditionTrue
+	}
+	return corev1.ConditionFalse
+}
+
+// sparkUIEndpointStatus computes the status of the endpoint of a driver, conditions are the
+// current ones.
+func (c *Controller) sparkUIEndpointStatus(driver *corev1.Service,
+	conditions []SparkUIEndpointCondition) (SparkUIEndpointStatus, error) {
+	ui, err := c.newSparkUI(driver)
+	if err != nil {
+		return SparkUIEndpointStatus{}, err
+	}
+	status := SparkUIEndpointStatus{
+		URL:           ui.URL,
+		Backend:       c.getConfig().Backend,
+		Route:         ui.Route,
+		RouteAccepted: ui.RouteStatus == "valid",
+		Health:        ui.Health,
+	}
+
+	routeReason, routeMessage := "Valid", ui.RouteDescription
+	switch {
+	case ui.Route == "" && ui.Pending != "":
+		routeReason, routeMessage = "Pending", ui.Pending
+	case ui.Route == "":
+		routeReason, routeMessage = "NoRoute", "the ingress route does not exist"
+	case ui.RouteStatus == "":
+		routeReason, routeMessage = "NotProcessed", "contour has not processed the ingress route"
+	case !status.RouteAccepted:
+		routeReason = "Invalid"
+	}
+
+	upstreamReason, upstreamMessage := "NoUIService", "the ui service does not exist"
+	if ui.UIService != "" {
+		endpoints, err := c.endpointsLister.Endpoints(driver.Namespace).Get(ui.UIService)
+		switch {
+		case err != nil && !errors.IsNotFound(err):
+			return status, err
+		case err == nil && hasReadyUIAddress(endpoints):
+			status.UpstreamReachable = true
+			upstreamReason, upstreamMessage = "EndpointsReady", ""
+		default:
+			upstreamReason, upstreamMessage = "NoReadyEndpoints", "the driver pod is not ready"
+		}
+	}
+	// the prober tells whether the ready endpoint actually answers.
+	if probe := c.prober.state(driver, probeTargetService); status.UpstreamReachable && probe.known {
+		if probe.up {
+			upstreamReason, upstreamMessage = "ProbeSucceeded", ""
+		} else {
+			status.UpstreamReachable = false
+			upstreamReason, upstreamMessage = "ProbeFailed", probe.lastError
+		}
+	}
+
+	readyReason, readyMessage := "Ready", ""
+	switch {
+	case ui.Finished():
+		readyReason, readyMessage = "Finished", "the spark application has finished"
+	case !status.RouteAccepted:
+		readyReason, readyMessage = routeReason, routeMessage
+	case !status.UpstreamReachable:
+		readyReason, readyMessage = upstreamReason, upstreamMessage
+	}
+	ready := readyReason == "Ready"
+
+	now := metav1.Now()
+	conditions = append([]SparkUIEndpointCondition(nil), conditions...)
+	conditions = setCondition(conditions, SparkUIEndpointCondition{Type: conditionRouteAccepted,
+		Status: conditionStatus(status.RouteAccepted), Reason: routeReason, Message: routeMessage}, now)
+	conditions = setCondition(conditions, SparkUIEndpointCondition{Type: conditionUpstreamReachable,
+		Status: conditionStatus(status.UpstreamReachable), Reason: upstreamReason, Message: upstreamMessage}, now)
+	conditions = setCondition(conditions, SparkUIEndpointCondition{Type: conditionReady,
+		Status: conditionStatus(ready), Reason: readyReason, Message: readyMessage}, now)
+	status.Conditions = conditions
+	return status, nil
+}
+
+// syncSparkUIEndpoint creates the endpoint of a driver service and refreshes its status, it does
+// nothing when the crd is not installed.
+func (c *Controller) syncSparkUIEndpoint(driver *corev1.Service) error {
+	if c.uiEndpointsLister == nil {
+		return nil
+	}
+	desired := NewSparkUIEndpoint(driver)
+	obj, err := c.uiEndpointsLister.ByNamespace(driver.Namespace).Get(desired.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	current := desired
+	if err == nil {
+		if current, err = endpointFromObject(obj); err != nil {
+			return err
+		}
+		if !isManagedBy(current.ObjectMeta, driver.Name) {
+			return fmt.Errorf("sparkuiendpoint %s/%s exists and is not managed by %s", current.Namespace,
+				current.Name, managedByValue)
+		}
+	} else {
+		current, err = c.createSparkUIEndpoint(desired)
+		if errors.IsAlreadyExists(err) {
+			// created by a previous sync the informer has not seen yet.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := c.sparkUIEndpointStatus(driver, current.Status.Conditions)
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(status, current.Status) {
+		return nil
+	}
+	updated := *current
+	updated.Status = status
+	return c.updateSparkUIEndpointStatus(current, &updated)
+}
+
+// deleteSparkUIEndpointOfDriver deletes the managed endpoint of a driver service whose spark ui is
+// no longer published, it does nothing when the crd is not installed.
+func (c *Controller) deleteSparkUIEndpointOfDriver(namespace, name string) error {
+	if c.uiEndpointsLister == nil {
+		return nil
+	}
+	endpointName := getSparkUIEndpointName(name)
+	obj, err := c.uiEndpointsLister.ByNamespace(namespace).Get(endpointName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	endpoint, err := endpointFromObject(obj)
+	if err != nil {
+		return err
+	}
+	if !isManagedBy(endpoint.ObjectMeta, name) {
+		return nil
+	}
+	klog.Infof("spark ui of %s/%s is no longer published, deleting sparkuiendpoint %s", namespace, name,
+		endpointName)
+	err = c.deleteSparkUIEndpoint(namespace, endpointName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// updateSparkUIEndpoints refreshes the endpoints of every driver service.
+func (c *Controller) updateSparkUIEndpoints() {
+	services, err := c.servicesLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List services failed: %s", err.Error())
+		return
+	}
+	for _, svc := range services {
+		if !c.isSparkDriverService(svc) {
+			continue
+		}
+		if err := c.syncSparkUIEndpoint(svc); err != nil {
+			klog.Errorf("Sync sparkuiendpoint of %s/%s failed: %s", svc.Namespace, svc.Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestCreatesSparkUIEndpoint(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	ingressRoute := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest,
+		driverService, testExposureOptions)
+	ingressRoute.Status.CurrentStatus = "valid"
+	driverPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "spark-driver-pod-name",
+			Namespace: metav1.NamespaceDefault,
+			Labels:    driverService.Spec.Selector,
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: uiService.Name, Namespace: metav1.NamespaceDefault},
+		Subsets: []corev1.EndpointSubset{
+			// an address not serving the ui does not make the upstream reachable on its own.
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}},
+				Ports: []corev1.EndpointPort{{Name: "driver-rpc-port", Port: 7078}}},
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+				Ports: []corev1.EndpointPort{{Name: sparkUIPortName, Port: sparkUIPort}}},
+		},
+	}
+
+	f.svcsLister = append(f.svcsLister, driverService, uiService)
+	f.svcsobjects = append(f.svcsobjects, driverService, uiService)
+	f.podsLister = append(f.podsLister, driverPod)
+	f.epsLister = append(f.epsLister, endpoints)
+	f.irsLister = append(f.irsLister, ingressRoute)
+	f.irsobjects = append(f.irsobjects, ingressRoute)
+
+	f.run(getKey(driverService, t))
+
+	u, err := f.dynamicclient.Resource(sparkUIEndpointResource).Namespace(metav1.NamespaceDefault).Get(
+		"test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get sparkuiendpoint: %v", err)
+	}
+	endpoint, err := endpointFromObject(u)
+	if err != nil {
+		t.Fatalf("convert sparkuiendpoint: %v", err)
+	}
+	if !isManagedBy(endpoint.ObjectMeta, driverService.Name) || endpoint.Spec.DriverService != driverService.Name {
+		t.Errorf("unexpected sparkuiendpoint %+v", endpoint.ObjectMeta)
+	}
+	status := endpoint.Status
+	if status.URL != "http://test-driver-svctest/" || status.Backend != backendIngressRoute ||
+		!status.RouteAccepted || !status.UpstreamReachable || status.Health != "ok" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if len(status.Conditions) != 3 || status.Conditions[2].Type != conditionReady ||
+		status.Conditions[2].Status != corev1.ConditionTrue {
+		t.Errorf("expected the endpoint to be ready, got %+v", status.Conditions)
+	}
+
+	c, _, _ := f.newController()
+	endpoints.Subsets = endpoints.Subsets[:1]
+	if status, err := c.sparkUIEndpointStatus(driverService, nil); err != nil || status.UpstreamReachable {
+		t.Errorf("expected the upstream to be unreachable without the ui port, got %+v, %v", status, err)
+	}
+}
+
+func TestSetConditionKeepsTransitionTime(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour))
+	now := metav1.Now()
+	conditions := []SparkUIEndpointCondition{
+		{Type: conditionReady, Status: corev1.ConditionTrue, LastTransitionTime: before},
+	}
+
+	conditions = setCondition(conditions, SparkUIEndpointCondition{Type: conditionReady,
+		Status: corev1.ConditionTrue, Reason: "Ready"}, now)
+	if !conditions[0].LastTransitionTime.Equal(&before) || conditions[0].Reason != "Ready" {
+		t.Errorf("expected the transition time to be kept, got %+v", conditions[0])
+	}
+	conditions = setCondition(conditions, SparkUIEndpointCondition{Type: conditionReady,
+		Status: corev1.ConditionFalse}, now)
+	if !conditions[0].LastTransitionTime.Equal(&now) {
+		t.Errorf("expected a new transition time, got %+v", conditions[0])
+	}
+	conditions = setCondition(conditions, SparkUIEndpointCondition{Type: conditionRouteAccepted,
+		Status: corev1.ConditionFalse}, now)
+	if len(conditions) != 2 {
+		t.Errorf("expected the condition to be added, got %+v", conditions)
+	}
+}
+
+func TestDeletesSparkUIEndpointOfUndetectedDriver(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	endpoint, err := runtime.DefaultUnstructuredConverter.ToUnstructured(NewSparkUIEndpoint(driverService))
+	if err != nil {
+		t.Fatalf("convert sparkuiendpoint: %v", err)
+	}
+	// the detection rules no longer match the driver service.
+	delete(driverService.Spec.Selector, "spark-role")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.uiEndpointsLister = append(f.uiEndpointsLister, &unstructured.Unstructured{Object: endpoint})
+	c, _, _ := f.newController()
+
+	if err := c.syncHandler(getKey(driverService, t)); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+
+	actions := filterInformerActions(f.dynamicclient.Actions())
+	if len(actions) != 1 || !actions[0].Matches("delete", sparkUIEndpointResource.Resource) ||
+		actions[0].(clientgotesting.DeleteAction).GetName() != getSparkUIEndpointName(driverService.Name) {
+		t.Errorf("expected the sparkuiendpoint to be deleted, got %+v", actions)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// annotationPrefix is shared by every annotation the controller reads or writes.
+	annotationPrefix = "spark-ui.ushareit.com/"
+	// readOnlyAnnotation on a namespace overrides the global -read_only flag, "true" or "false".
+	readOnlyAnnotation = annotationPrefix + "read-only"
+	// sourceRangesAnnotation on a namespace or driver service replaces the global
+	// -source_ranges allowlist, a comma separated list of CIDRs.
+	sourceRangesAnnotation = annotationPrefix + "source-ranges"
+)
+
+// reasonSparkUIWithheld is the event recorded on a driver service whose route is not published
+// because the backend can not enforce its exposure options.
+const reasonSparkUIWithheld = "SparkUIWithheld"
+
+// reasonInvalidExposureOptions is the event recorded on a driver service whose exposure options
+// are invalid, its spark ui is not published until they are fixed.
+const reasonInvalidExposureOptions = "InvalidExposureOptions"
+
+// routeAnnotations are the annotations of the ingress routes kept in sync with the desired route,
+// the others are left to their owners.
+var routeAnnotations = []string{externalDNSTargetAnnotation, externalDNSTTLAnnotation}
+
+// spark ui endpoints that change the state of a running application, the proxy rejects them in
+// read only mode whatever the method.
+var sparkUIKillPaths = []string{"/jobs/job/kill", "/stages/stage/kill"}
+
+// readOnlyRewritePath is where the routes of a read only spark ui send its kill endpoints, the
+// spark ui answers 404.
+const readOnlyRewritePath = "/spark-ui-read-only"
+
+// isKillPath tells whether a cleaned path is a kill endpoint or below one, matching whole segments.
+func isKillPath(p string) bool {
+	for _, kill := range sparkUIKillPaths {
+		if p == kill || strings.HasPrefix(p, kill+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ExposureOptions are the per driver settings used when building the spark ui
+// service and ingress route.
+type ExposureOptions struct {
+	// ReadOnly blocks the job and stage kill endpoints, and in the built-in proxy the requests
+	// other than GET and HEAD.
+	ReadOnly bool
+	// SourceRanges are the CIDRs allowed to reach the spark ui, empty allows everyone.
+	SourceRanges []string
+	// RequestTimeout is the envoy timeout of the requests to the spark ui.
+	RequestTimeout string
+	// HostSuffix ends the host name of the spark ui.
+	HostSuffix string
+	// PerTryTimeout and Retries are the envoy retry policy, no retries when both are empty.
+	PerTryTimeout string
+	Retries       int
+	// HealthCheck is the active health check of the ui service, disabled when its path is empty.
+	HealthCheck HealthCheckConfig
+	// ExternalDNSTargets are annotated on the route for external-dns, with ExternalDNSTTL, in the
+	// annotations dns mode once the load balancer has an address.
+	ExternalDNSTargets []string
+	ExternalDNSTTL     int64
+	// AuthURL authorizes the requests to the spark ui, see SparkUIAuth, no auth when empty.
+	AuthURL string
+}
+
+// exposureOptions resolves the options for a driver service from the configuration, the
+// exposure policies and the annotations on the driver's namespace and the driver service itself.
+func (c *Controller) exposureOptions(driver *corev1.Service) (ExposureOptions, error) {
+	defaults, err := c.applyExposurePolicies(c.getConfig().exposureOptions(), driver.Namespace)
+	if err != nil {
+		return defaults, err
+	}
+	if c.getConfig().DNS.Mode == dnsModeAnnotations {
+		defaults.ExternalDNSTargets, _ = c.loadBalancerTargets()
+	}
+	ns, err := c.namespacesLister.Get(driver.Namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Warningf("Get namespace: %s failed: %s, using global options", driver.Namespace, err.Error())
+		}
+		ns = nil
+	}
+	return resolveExposureOptions(defaults, ns, driver)
+}
+
+// resolveExposureOptions overrides the defaults with the annotations of the namespace, which
+// may be nil, and of the driver service, the most specific one wins. An invalid annotation is an
+// error rather than being ignored, so a typo never exposes a ui that was meant to be restricted.
+func resolveExposureOptions(defaults ExposureOptions, ns *corev1.Namespace, driver *corev1.Service) (ExposureOptions, error) {
+	opts := defaults
+	var err error
+	if ns != nil {
+		if v, ok := ns.Annotations[readOnlyAnnotation]; ok {
+			if opts.ReadOnly, err = strconv.ParseBool(v); err != nil {
+				return opts, fmt.Errorf("namespace %s: invalid %s annotation %q", ns.Name, readOnlyAnnotation, v)
+			}
+		}
+		// an empty annotation is unset, it does not lift the restrictions of the defaults.
+		if v := strings.TrimSpace(ns.Annotations[sourceRangesAnnotation]); v != "" {
+			if opts.SourceRanges, err = parseSourceRanges(v); err != nil {
+				return opts, fmt.Errorf("namespace %s: %s", ns.Name, err.Error())
+			}
+		}
+	}
+	if v := strings.TrimSpace(driver.Annotations[sourceRangesAnnotation]); v != "" {
+		if opts.SourceRanges, err = parseSourceRanges(v); err != nil {
+			return opts, fmt.Errorf("service %s/%s: %s", driver.Namespace, driver.Name, err.Error())
+		}
+	}
+	return opts, nil
+}
+
+// unenforceableOptions tells why the ingressroute backend can not enforce the exposure options of
+// a driver and where the spark ui is served instead, it is empty when it can. The source ranges
+// are enforced by the load balancer of the ui service, see NewSparkUIService.
+func unenforceableOptions(opts ExposureOptions) string {
+	if len(opts.SourceRanges) > 0 {
+		return "IngressRoute v1beta1 of contour v0.14 has no ip filter, the source ranges are enforced by " +
+			"the load balancer of the spark ui service and the built-in proxy"
+	}
+	if opts.AuthURL != "" {
+		return "IngressRoute v1beta1 of contour v0.14 has no external authorization, the auth of the " +
+			"exposure policy is enforced by the built-in proxy"
+	}
+	return ""
+}
+
+// namespaceEventHandler re-enqueues the drivers of a namespace whose annotations changed, they
+// override the exposure options of its drivers.
+func (c *Controller) namespaceEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNs, ok := oldObj.(*corev1.Namespace)
+			newNs, ok2 := newObj.(*corev1.Namespace)
+			if !ok || !ok2 || reflect.DeepEqual(oldNs.Annotations, newNs.Annotations) {
+				return
+			}
+			c.enqueueDriverServices(newNs.Name)
+		},
+	}
+}
+
+// parseSourceRanges parses a comma separated list of CIDRs, a bare ip is treated as a
+// single host.
+func parseSourceRanges(value string) ([]string, error) {
+	var ranges []string
+	for _, r := range strings.Split(value, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if !strings.Contains(r, "/") {
+			if ip := net.ParseIP(r); ip != nil && ip.To4() != nil {
+				r += "/32"
+			} else {
+				r += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source range %q", r)
+		}
+		ranges = append(ranges, ipNet.String())
+	}
+	return ranges, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	federatedPath = apiPrefix + "federated/"
+	// federationConcurrency bounds how many drivers are queried at the same time.
+	federationConcurrency = 10
+	// federationMaxResponse bounds how much of a driver response is read.
+	federationMaxResponse = 32 << 20
+)
+
+// spark rest api resources that can be federated, by the path under /api/v1/federated/.
+var federatedResources = map[string]func(appID string) string{
+	"applications": func(appID string) string { return "/api/v1/applications/" + appID },
+	"jobs":         func(appID string) string { return "/api/v1/applications/" + appID + "/jobs" },
+	"executors":    func(appID string) string { return "/api/v1/applications/" + appID + "/executors" },
+}
+
+// federationError records a driver that could not be queried.
+type federationError struct {
+	Namespace     string `json:"namespace"`
+	DriverService string `json:"driverService"`
+	Error         string `json:"error"`
+}
+
+// federatedResult is the merged response of every driver, each item is the spark rest api
+// object with namespace, driverService and appId added.
+type federatedResult struct {
+	Items   []map[string]interface{} `json:"items"`
+	Errors  []federationError        `json:"errors"`
+	Fetched time.Time                `json:"fetched"`
+}
+
+// Federation aggregates the spark rest api of every live driver managed by the controller:
+//
+//	GET /api/v1/federated/applications?namespace=
+//	GET /api/v1/federated/jobs?namespace=
+//	GET /api/v1/federated/executors?namespace=
+//
+// Results are cached for a short time so polling clients do not overload the drivers, and
+// concurrent requests for a result that is not cached share a single query of the drivers.
+type Federation struct {
+	controller *Controller
+	client     *http.Client
+	cacheTTL   time.Duration
+	// serviceURL is the address of a spark ui service, the tests point it at a fake driver.
+	serviceURL func(namespace, uiService string) string
+
+	lock  sync.Mutex
+	cache map[string]*federatedResult
+	calls map[string]*federationCall
+}
+
+// federationCall is a query of the drivers in flight, waited for by every request of its key.
+type federationCall struct {
+	done   chan struct{}
+	result *federatedResult
+	err    error
+}
+
+// NewFederation returns a new federated spark rest api, timeout bounds each driver request.
+func NewFederation(controller *Controller, timeout, cacheTTL time.Duration) *Federation {
+	return &Federation{
+		controller: controller,
+		client:     &http.Client{Timeout: timeout},
+		cacheTTL:   cacheTTL,
+		serviceURL: sparkUIServiceURL,
+		cache:      map[string]*federatedResult{},
+		calls:      map[string]*federationCall{},
+	}
+}
+
+func (f *Federation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	resource := strings.TrimPrefix(r.URL.Path, federatedPath)
+	if _, ok := federatedResources[resource]; !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown resource "+resource)
+		return
+	}
+	result, err := f.get(resource, r.URL.Query().Get("namespace"))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// get returns the cached result for resource in namespace, querying the drivers when it is stale.
+// The namespace comes from the client, so expired results are evicted rather than kept forever.
+func (f *Federation) get(resource, namespace string) (*federatedResult, error) {
+	key := resource + "/" + namespace
+	f.lock.Lock()
+	if cached, ok := f.cache[key]; ok && time.Since(cached.Fetched) < f.cacheTTL {
+		f.lock.Unlock()
+		return cached, nil
+	}
+	if call, ok := f.calls[key]; ok {
+		f.lock.Unlock()
+		<-call.done
+		return call.result, call.err
+	}
+	call := &federationCall{done: make(chan struct{})}
+	f.calls[key] = call
+	f.lock.Unlock()
+
+	call.result, call.err = f.fetch(resource, namespace)
+
+	f.lock.Lock()
+	delete(f.calls, key)
+	for k, cached := range f.cache {
+		if time.Since(cached.Fetched) >= f.cacheTTL {
+			delete(f.cache, k)
+		}
+	}
+	if call.err == nil {
+		f.cache[key] = call.result
+	}
+	f.lock.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
+
+// fetch queries every live driver in parallel, a failing driver is reported in the
+// result rather than failing the whole request.
+func (f *Federation) fetch(resource, namespace string) (*federatedResult, error) {
+	uis, err := f.controller.listSparkUIs(namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := &federatedResult{
+		Items:   []map[string]interface{}{},
+		Errors:  []federationError{},
+		Fetched: time.Now(),
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, federationConcurrency)
+	for _, ui := range uis {
+		if ui.Finished() || ui.UIService == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(ui SparkUI) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			items, err := f.fetchDriver(ui, resource)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				klog.Warningf("Query spark %s of %s/%s failed: %s", resource, ui.Namespace, ui.DriverService,
+					err.Error())
+				result.Errors = append(result.Errors, federationError{
+					Namespace:     ui.Namespace,
+					DriverService: ui.DriverService,
+					Error:         err.Error(),
+				})
+				return
+			}
+			result.Items = append(result.Items, items...)
+		}(ui)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// fetchDriver queries the spark rest api of a single driver through its ui service.
+func (f *Federation) fetchDriver(ui SparkUI, resource string) ([]map[string]interface{}, error) {
+	if ui.AppID == "" {
+		return nil, fmt.Errorf("driver service has no %s selector", sparkAppSelectorLabel)
+	}
+	url := f.serviceURL(ui.Namespace, ui.UIService) + federatedResources[resource](ui.AppID)
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, federationMaxResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	// applications/{id} is a single object, jobs and executors are lists.
+	var items []map[string]interface{}
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		var item map[string]interface{}
+		err = json.Unmarshal(body, &item)
+		items = append(items, item)
+	} else {
+		err = json.Unmarshal(body, &items)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %s", url, err.Error())
+	}
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		item["namespace"] = ui.Namespace
+		item["driverService"] = ui.DriverService
+		item["appId"] = ui.AppID
+	}
+	return items, nil
+}
+
+// sparkUIServiceURL is the in cluster address of a spark ui service.
+func sparkUIServiceURL(namespace, uiService string) string {
+	return fmt.Sprintf("http://%s.%s.svc:%d", uiService, namespace, sparkUIPort)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newFederationFixture returns a federation over two running drivers, a-driver-svc answered by
+// handler and b-driver-svc whose ui returns errors.
+func newFederationFixture(t *testing.T, handler http.Handler) (*Federation, func()) {
+	f := newFixture(t)
+	for _, name := range []string{"a-driver-svc", "b-driver-svc"} {
+		driverService := newSparkDriverService(name)
+		f.svcsLister = append(f.svcsLister, driverService, NewSparkUIService(driverService, ExposureOptions{}))
+		f.addReadyDriverPod(driverService)
+	}
+	c, _, _ := f.newController()
+
+	good := httptest.NewServer(handler)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "driver is busy", http.StatusServiceUnavailable)
+	}))
+	federation := NewFederation(c, time.Second, time.Hour)
+	federation.serviceURL = func(namespace, uiService string) string {
+		if uiService == "a-ui-svc" {
+			return good.URL
+		}
+		return bad.URL
+	}
+	return federation, func() {
+		good.Close()
+		bad.Close()
+	}
+}
+
+// jobsHandler answers the jobs of the spark-test application and counts the requests.
+func jobsHandler(hits *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		if r.URL.Path != "/api/v1/applications/spark-test/jobs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"jobId": 1, "status": "RUNNING"}, {"jobId": 0, "status": "SUCCEEDED"}]`))
+	})
+}
+
+func TestFederationMergesDrivers(t *testing.T) {
+	var hits int32
+	federation, stop := newFederationFixture(t, jobsHandler(&hits))
+	defer stop()
+
+	result, err := federation.get("jobs", metav1.NamespaceDefault)
+	if err != nil {
+		t.Fatalf("federated jobs: %v", err)
+	}
+	if len(result.Items) != 2 {
+		t.Fatalf("expected the 2 jobs of a-driver-svc, got %v", result.Items)
+	}
+	for _, item := range result.Items {
+		if item["namespace"] != metav1.NamespaceDefault || item["driverService"] != "a-driver-svc" ||
+			item["appId"] != "spark-test" {
+			t.Errorf("unexpected job %v", item)
+		}
+	}
+	// the failing driver is reported without failing the request.
+	if len(result.Errors) != 1 || result.Errors[0].DriverService != "b-driver-svc" {
+		t.Errorf("expected an error for b-driver-svc, got %+v", result.Errors)
+	}
+}
+
+func TestFederationCache(t *testing.T) {
+	var hits int32
+	federation, stop := newFederationFixture(t, jobsHandler(&hits))
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		if _, err := federation.get("jobs", metav1.NamespaceDefault); err != nil {
+			t.Fatalf("federated jobs: %v", err)
+		}
+	}
+	if hits := atomic.LoadInt32(&hits); hits != 1 {
+		t.Errorf("expected the second request to be cached, got %d driver requests", hits)
+	}
+
+	// an expired result is evicted by the next query, whatever its key.
+	federation.cache["jobs/"+metav1.NamespaceDefault].Fetched = time.Now().Add(-2 * time.Hour)
+	if _, err := federation.get("jobs", "other"); err != nil {
+		t.Fatalf("federated jobs: %v", err)
+	}
+	if _, ok := federation.cache["jobs/"+metav1.NamespaceDefault]; ok || len(federation.cache) != 1 {
+		t.Errorf("expected the expired result to be evicted, got %v", federation.cache)
+	}
+}
+
+func TestFederationSharesConcurrentQueries(t *testing.T) {
+	var hits int32
+	var once sync.Once
+	started := make(chan struct{})
+	release := make(chan struct{})
+	jobs := jobsHandler(&hits)
+	federation, stop := newFederationFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		jobs.ServeHTTP(w, r)
+	}))
+	defer stop()
+
+	var wg sync.WaitGroup
+	results := make([]*federatedResult, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = federation.get("jobs", metav1.NamespaceDefault)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = federation.get("jobs", metav1.NamespaceDefault)
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+
+	if hits := atomic.LoadInt32(&hits); hits != 1 {
+		t.Errorf("expected a single query of the driver, got %d", hits)
+	}
+	for i, result := range results {
+		if result == nil || len(result.Items) != 2 {
+			t.Errorf("request %d got %+v", i, result)
+		}
+	}
+}
@@ -18,4 +18,12 @@ require (
 	k8s.io/klog v0.3.1
 	k8s.io/kube-openapi v0.0.0-20190228160746-b3a7cee44a30 // indirect
 	k8s.io/utils v0.0.0-20190809000727-6c36bc71fc4a
+	sigs.k8s.io/yaml v1.1.0
+)
+
+// contour v0.14.1 requires pseudo-versions whose timestamps do not match their commits, the
+// module proxy refuses them.
+replace (
+	golang.org/x/sys v0.0.0-20190629205408-04f50cda93cb => golang.org/x/sys v0.0.0-20190626221950-04f50cda93cb
+	google.golang.org/genproto v0.0.0-20190611190200-a7e196e89fd3 => google.golang.org/genproto v0.0.0-20190530194941-fb225487d101
 )
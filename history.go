@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// historyRedirectServiceName is the ExternalName service created in every namespace with
+	// finished applications, it points the redirect routes at the controller.
+	historyRedirectServiceName = "spark-ui-history-redirect"
+	// historyAppIDAnnotation on an ingress route marks it as redirecting to the history server,
+	// its value is the spark application id.
+	historyAppIDAnnotation = annotationPrefix + "history-app-id"
+	// historyExpiresAnnotation is when a redirect route is deleted, in RFC3339.
+	historyExpiresAnnotation = annotationPrefix + "history-expires"
+)
+
+// HistoryRedirectOptions configures how the ui of a finished application is redirected to
+// the spark history server.
+type HistoryRedirectOptions struct {
+	// Enabled turns on the redirect, it also needs the history server url.
+	Enabled bool
+	// Retention is how long the redirect is kept after the driver terminated.
+	Retention time.Duration
+	// ControllerHost and ControllerPort are the in cluster address of the controller's http server.
+	ControllerHost string
+	ControllerPort int
+}
+
+// sparkDriverFinished reports whether the driver pod has terminated or is terminating.
+func sparkDriverFinished(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp != nil ||
+		pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// enqueueDriverServicesForPod queues the driver services selecting a driver pod, so
+// changes of the pod are reconciled.
+func (c *Controller) enqueueDriverServicesForPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Labels["spark-role"] != "driver" {
+		return
+	}
+	services, err := c.servicesLister.Services(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List services in %s failed: %s", pod.Namespace, err.Error())
+		return
+	}
+	for _, svc := range services {
+		if c.isSparkDriverService(svc) && labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			c.workqueue.Add(pod.Namespace + "/" + svc.Name)
+		}
+	}
+}
+
+// redirectSparkUIToHistory replaces the routes of a finished driver's ingress route with the
+// history redirect. The owner references are dropped so the route outlives the driver pod,
+// expireHistoryRoutes deletes it after the retention period.
+func (c *Controller) redirectSparkUIToHistory(driver *corev1.Service) error {
+	appID := driver.Spec.Selector[sparkAppSelectorLabel]
+	if appID == "" {
+		klog.Infof("Driver service: %s/%s has no %s selector, not redirecting to history server",
+			driver.Namespace, driver.Name, sparkAppSelectorLabel)
+		return nil
+	}
+	ingressName := c.getSparkUIIngressRouteName(getSparkUIServiceName(driver.Name))
+	route, err := c.ingressRoutesLister.IngressRoutes(driver.Namespace).Get(ingressName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// the ui was never exposed, there is no link to keep working.
+			return nil
+		}
+		return err
+	}
+	if route.Annotations[historyAppIDAnnotation] != "" {
+		return nil
+	}
+	if err := c.createHistoryRedirectServiceIfNotExists(driver.Namespace); err != nil {
+		return err
+	}
+
+	klog.Infof("spark driver: %s/%s finished, redirecting %s to history server", driver.Namespace,
+		driver.Name, ingressName)
+	current := route
+	route = route.DeepCopy()
+	route.OwnerReferences = nil
+	if route.Annotations == nil {
+		route.Annotations = map[string]string{}
+	}
+	route.Annotations[historyAppIDAnnotation] = appID
+	route.Annotations[historyExpiresAnnotation] = time.Now().Add(c.historyRedirect.Retention).UTC().Format(time.RFC3339)
+	route.Spec.Routes = []contourv1.Route{
+		{
+			Match: "/",
+			Services: []contourv1.Service{
+				{
+					Name: historyRedirectServiceName,
+					Port: c.historyRedirect.ControllerPort,
+				},
+			},
+		},
+	}
+	_, err = c.updateIngressRoute(current, route)
+	return err
+}
+
+// createHistoryRedirectServiceIfNotExists creates the ExternalName service pointing at the
+// controller in a namespace, it is shared by all redirect routes of the namespace.
+func (c *Controller) createHistoryRedirectServiceIfNotExists(namespace string) error {
+	_, err := c.servicesLister.Services(namespace).Get(historyRedirectServiceName)
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	_, err = c.createService(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      historyRedirectServiceName,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedByValue},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: c.historyRedirect.ControllerHost,
+			Ports: []corev1.ServicePort{
+				{
+					Name:     "http",
+					Port:     int32(c.historyRedirect.ControllerPort),
+					Protocol: corev1.ProtocolTCP,
+				},
+			},
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// expireHistoryRoutes deletes the redirect routes whose retention period is over, and the redirect
+// service of a namespace once its last redirect route expired.
+func (c *Controller) expireHistoryRoutes() {
+	routes, err := c.ingressRoutesLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List ingress routes failed: %s", err.Error())
+		return
+	}
+	// the namespaces with expired redirect routes, and the ones still redirecting.
+	expired, redirecting := map[string]bool{}, map[string]bool{}
+	for _, route := range routes {
+		expires, ok := route.Annotations[historyExpiresAnnotation]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			klog.Warningf("Ingress route: %s/%s has invalid %s annotation %q", route.Namespace, route.Name,
+				historyExpiresAnnotation, expires)
+			redirecting[route.Namespace] = true
+			continue
+		}
+		if time.Now().Before(t) {
+			redirecting[route.Namespace] = true
+			continue
+		}
+		klog.Infof("history redirect: %s/%s expired, deleting it", route.Namespace, route.Name)
+		err = c.deleteIngressRoute(route.Namespace, route.Name)
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Delete ingress route: %s/%s failed: %s", route.Namespace, route.Name, err.Error())
+			redirecting[route.Namespace] = true
+			continue
+		}
+		expired[route.Namespace] = true
+	}
+	for namespace := range expired {
+		if !redirecting[namespace] {
+			c.deleteHistoryRedirectService(namespace)
+		}
+	}
+}
+
+// deleteHistoryRedirectService deletes the redirect service of a namespace without redirect
+// routes, unless it is not managed by the controller.
+func (c *Controller) deleteHistoryRedirectService(namespace string) {
+	svc, err := c.servicesLister.Services(namespace).Get(historyRedirectServiceName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Get service: %s/%s failed: %s", namespace, historyRedirectServiceName, err.Error())
+		}
+		return
+	}
+	if svc.Labels[managedByLabel] != managedByValue {
+		return
+	}
+	klog.Infof("history redirect: no redirect route left in %s, deleting service %s", namespace,
+		historyRedirectServiceName)
+	err = c.deleteService(namespace, historyRedirectServiceName)
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Delete service: %s/%s failed: %s", namespace, historyRedirectServiceName, err.Error())
+	}
+}
+
+// HistoryRedirector serves the ui of a finished application from its snapshot when there is
+// one, and redirects to the spark history server otherwise. Requests for any other host are
+// passed to next.
+type HistoryRedirector struct {
+	controller *Controller
+	next       http.Handler
+}
+
+// NewHistoryRedirector returns a new history server redirect handler.
+func NewHistoryRedirector(controller *Controller, next http.Handler) *HistoryRedirector {
+	return &HistoryRedirector{controller: controller, next: next}
+}
+
+func (h *HistoryRedirector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	// the redirect routes of finished drivers send their host, policies may give them any suffix,
+	// every other request is for the portal.
+	route, err := h.historyRoute(host)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	appID := route.Annotations[historyAppIDAnnotation]
+	snapshots := h.controller.snapshots
+	if snapshots != nil && snapshots.has(route.Namespace, appID) {
+		snapshots.serve(w, r, route.Namespace, appID)
+		return
+	}
+	if h.controller.historyServerURL == "" {
+		http.Error(w, "the driver of "+appID+" has terminated", http.StatusNotFound)
+		return
+	}
+	target := fmt.Sprintf("%s/history/%s%s", strings.TrimSuffix(h.controller.historyServerURL, "/"), appID,
+		r.URL.Path)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// historyRoute finds the redirect route serving host.
+func (h *HistoryRedirector) historyRoute(host string) (*contourv1.IngressRoute, error) {
+	routes, err := h.controller.ingressRoutesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		if route.Annotations[historyAppIDAnnotation] != "" && route.Spec.VirtualHost != nil &&
+			route.Spec.VirtualHost.Fqdn == host {
+			return route, nil
+		}
+	}
+	return nil, fmt.Errorf("no finished spark application is served on %s", host)
+}
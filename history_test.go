@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+// newHistoryRoute returns the redirect route of a finished driver, expiring at expires.
+func newHistoryRoute(driverName, expires string) *contourv1.IngressRoute {
+	driverService := newSparkDriverService(driverName)
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	route := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest, driverService, testExposureOptions)
+	route.OwnerReferences = nil
+	route.Annotations = map[string]string{
+		historyAppIDAnnotation:   "spark-test",
+		historyExpiresAnnotation: expires,
+	}
+	return route
+}
+
+func TestRedirectsSparkUIOfFinishedDriver(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	route := NewSparkUIIngressRoute(uiService, driverService.Name+hostSuffixTest, driverService, testExposureOptions)
+	f.svcsLister = append(f.svcsLister, driverService, uiService)
+	f.irsLister = append(f.irsLister, route)
+	f.irsobjects = append(f.irsobjects, route)
+	f.addReadyDriverPod(driverService)
+	f.podsLister[0].Status.Phase = corev1.PodSucceeded
+	c, _, _ := f.newController()
+	c.historyRedirect = HistoryRedirectOptions{Enabled: true, Retention: time.Hour,
+		ControllerHost: "spark-ui-controller.kube-system.svc", ControllerPort: 8081}
+
+	if err := c.syncHandler(getKey(driverService, t)); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+
+	svcsactions := filterInformerActions(f.kubeclient.Actions())
+	if len(svcsactions) != 1 || !svcsactions[0].Matches("create", "services") {
+		t.Fatalf("expected the redirect service to be created, got %+v", svcsactions)
+	}
+	redirectService := svcsactions[0].(clientgotesting.CreateAction).GetObject().(*corev1.Service)
+	if redirectService.Name != historyRedirectServiceName || redirectService.Spec.ExternalName != c.historyRedirect.ControllerHost {
+		t.Errorf("unexpected redirect service %+v", redirectService)
+	}
+
+	irsactions := filterInformerActions(f.contourclient.Actions())
+	if len(irsactions) != 1 || !irsactions[0].Matches("update", "ingressroutes") {
+		t.Fatalf("expected the route to be updated, got %+v", irsactions)
+	}
+	redirect := irsactions[0].(clientgotesting.UpdateAction).GetObject().(*contourv1.IngressRoute)
+	if redirect.Annotations[historyAppIDAnnotation] != "spark-test" || len(redirect.OwnerReferences) != 0 {
+		t.Errorf("expected an unowned redirect route of spark-test, got %+v", redirect.ObjectMeta)
+	}
+	expires, err := time.Parse(time.RFC3339, redirect.Annotations[historyExpiresAnnotation])
+	if err != nil || expires.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("expected the redirect to expire after the retention, got %q", redirect.Annotations[historyExpiresAnnotation])
+	}
+	services := redirect.Spec.Routes[0].Services
+	if len(services) != 1 || services[0].Name != historyRedirectServiceName || services[0].Port != 8081 {
+		t.Errorf("expected the route to point at the redirect service, got %+v", services)
+	}
+}
+
+func TestExpiresHistoryRoutes(t *testing.T) {
+	f := newFixture(t)
+	expired := newHistoryRoute("expired-driver-svc", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	retained := newHistoryRoute("retained-driver-svc", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	invalid := newHistoryRoute("invalid-driver-svc", "tomorrow")
+	f.irsLister = append(f.irsLister, expired, retained, invalid)
+	f.irsobjects = append(f.irsobjects, expired, retained, invalid)
+	c, _, _ := f.newController()
+
+	c.expireHistoryRoutes()
+
+	f.expectDeleteIngressRouteAction(expired.Namespace, expired.Name)
+	irsactions := filterInformerActions(f.contourclient.Actions())
+	if len(irsactions) != 1 {
+		t.Fatalf("expected only the expired route to be deleted, got %+v", irsactions)
+	}
+	checkAction(f.irsactions[0], irsactions[0], t)
+}
+
+func TestHistoryRedirector(t *testing.T) {
+	f := newFixture(t)
+	route := newHistoryRoute("test-driver-svc", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	f.irsLister = append(f.irsLister, route)
+	c, _, _ := f.newController()
+	c.historyServerURL = "http://history.example.com:18080/"
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	redirector := NewHistoryRedirector(c, next)
+	get := func(host, url string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		redirector.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(route.Spec.VirtualHost.Fqdn+":80", "/jobs/job/?id=1")
+	if w.Code != http.StatusFound ||
+		w.Header().Get("Location") != "http://history.example.com:18080/history/spark-test/jobs/job/?id=1" {
+		t.Errorf("expected a redirect to the history server, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// the requests for other hosts are for the portal.
+	if w := get("portal.example.com", "/"); w.Code != http.StatusTeapot {
+		t.Errorf("expected the request to be passed on, got %d", w.Code)
+	}
+
+	c.historyServerURL = ""
+	if w := get(route.Spec.VirtualHost.Fqdn, "/"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without a history server, got %d", w.Code)
+	}
+}
+
+func TestDeletesHistoryRedirectServiceWithLastRoute(t *testing.T) {
+	f := newFixture(t)
+	newRedirectService := func(namespace string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: historyRedirectServiceName, Namespace: namespace,
+			Labels: map[string]string{managedByLabel: managedByValue}}}
+	}
+	// the last redirect route of the other namespace expired, the default namespace still redirects.
+	expired := newHistoryRoute("expired-driver-svc", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	expired.Namespace = "other"
+	expiredDefault := newHistoryRoute("expired-driver-svc", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	retained := newHistoryRoute("retained-driver-svc", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	f.irsLister = append(f.irsLister, expired, expiredDefault, retained)
+	f.irsobjects = append(f.irsobjects, expired, expiredDefault, retained)
+	f.svcsLister = append(f.svcsLister, newRedirectService("other"), newRedirectService(metav1.NamespaceDefault))
+	c, _, _ := f.newController()
+
+	c.expireHistoryRoutes()
+
+	f.expectDeleteServiceAction("other", historyRedirectServiceName)
+	svcsactions := filterInformerActions(f.kubeclient.Actions())
+	if len(svcsactions) != 1 {
+		t.Fatalf("expected only the redirect service of the other namespace to be deleted, got %+v", svcsactions)
+	}
+	checkAction(f.svcsactions[0], svcsactions[0], t)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// sparkAppSelectorLabel is set by spark on the driver pod and the driver service selector,
+// its value is the spark application id.
+const sparkAppSelectorLabel = "spark-app-selector"
+
+// SparkUI describes a spark driver managed by the controller and where its ui is exposed.
+type SparkUI struct {
+	Namespace        string     `json:"namespace"`
+	AppName          string     `json:"appName"`
+	AppID            string     `json:"appId"`
+	DriverService    string     `json:"driverService"`
+	DriverPod        string     `json:"driverPod,omitempty"`
+	Phase            string     `json:"phase"`
+	Created          time.Time  `json:"created"`
+	UIService        string     `json:"uiService,omitempty"`
+	UIServiceCreated *time.Time `json:"uiServiceCreated,omitempty"`
+	Route            string     `json:"route,omitempty"`
+	RouteStatus      string     `json:"routeStatus,omitempty"`
+	RouteDescription string     `json:"routeDescription,omitempty"`
+	RouteCreated     *time.Time `json:"routeCreated,omitempty"`
+	// Pending tells why the route is not published yet.
+	Pending    string            `json:"pending,omitempty"`
+	Health     string            `json:"health"`
+	URL        string            `json:"url"`
+	HistoryURL string            `json:"historyUrl,omitempty"`
+	Labels     map[string]string `json:"-"`
+}
+
+// Finished reports whether the driver pod has completed. A driver without a pod has not started
+// yet, the driver service is garbage collected with its pod.
+func (ui SparkUI) Finished() bool {
+	return ui.Phase == string(corev1.PodSucceeded) || ui.Phase == string(corev1.PodFailed)
+}
+
+// health summarizes whether the ui can be reached through its route.
+func (ui SparkUI) health() string {
+	switch {
+	case ui.Finished():
+		return "finished"
+	case ui.UIService == "":
+		return "no ui service"
+	case ui.Route == "" && ui.Pending != "":
+		return "pending"
+	case ui.Route == "":
+		return "no route"
+	case ui.RouteStatus == "":
+		return "route pending"
+	case ui.RouteStatus != "valid":
+		return "route " + ui.RouteStatus
+	}
+	return "ok"
+}
+
+// Age is the time since the driver service was created.
+func (ui SparkUI) Age() time.Duration {
+	return time.Since(ui.Created).Round(time.Second)
+}
+
+// listSparkUIs returns every spark driver in namespace, all namespaces when empty, whose
+// service or pod labels match selector, sorted by namespace and newest first.
+func (c *Controller) listSparkUIs(namespace string, selector labels.Selector) ([]SparkUI, error) {
+	services, err := c.servicesLister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var uis []SparkUI
+	for _, svc := range services {
+		if !c.isSparkDriverService(svc) {
+			continue
+		}
+		ui, err := c.newSparkUI(svc)
+		if err != nil {
+			return nil, err
+		}
+		if selector.Matches(labels.Set(ui.Labels)) {
+			uis = append(uis, ui)
+		}
+	}
+	sort.Slice(uis, func(i, j int) bool {
+		if uis[i].Namespace != uis[j].Namespace {
+			return uis[i].Namespace < uis[j].Namespace
+		}
+		return uis[i].Created.After(uis[j].Created)
+	})
+	return uis, nil
+}
+
+// newSparkUI describes a single driver service.
+func (c *Controller) newSparkUI(driver *corev1.Service) (SparkUI, error) {
+	ui := SparkUI{
+		Namespace:     driver.Namespace,
+		AppName:       strings.TrimSuffix(driver.Name, driverServiceSuffix),
+		AppID:         driver.Spec.Selector[sparkAppSelectorLabel],
+		DriverService: driver.Name,
+		Created:       driver.CreationTimestamp.Time,
+		URL:           c.getSparkUIURL(driver),
+		Labels:        map[string]string{},
+	}
+	for k, v := range driver.Labels {
+		ui.Labels[k] = v
+	}
+	pod, err := c.getSparkDriverPod(driver)
+	if err != nil {
+		return ui, err
+	}
+	if pod != nil {
+		ui.DriverPod = pod.Name
+		ui.Phase = string(pod.Status.Phase)
+		for k, v := range pod.Labels {
+			ui.Labels[k] = v
+		}
+	}
+	uiService, err := c.servicesLister.Services(driver.Namespace).Get(getSparkUIServiceName(driver.Name))
+	if err != nil && !errors.IsNotFound(err) {
+		return ui, err
+	}
+	if err == nil {
+		created := uiService.CreationTimestamp.Time
+		ui.UIService = uiService.Name
+		ui.UIServiceCreated = &created
+		route, err := c.ingressRoutesLister.IngressRoutes(driver.Namespace).Get(
+			c.getSparkUIIngressRouteName(uiService.Name))
+		if err != nil && !errors.IsNotFound(err) {
+			return ui, err
+		}
+		if err == nil {
+			created := route.CreationTimestamp.Time
+			ui.Route = route.Name
+			ui.RouteStatus = route.Status.CurrentStatus
+			ui.RouteDescription = route.Status.Description
+			ui.RouteCreated = &created
+		}
+	}
+	if ui.Route == "" && !ui.Finished() {
+		if ui.Pending, err = c.sparkUIPendingReason(driver); err != nil {
+			return ui, err
+		}
+	}
+	if c.historyServerURL != "" && ui.AppID != "" {
+		ui.HistoryURL = strings.TrimSuffix(c.historyServerURL, "/") + "/history/" + ui.AppID + "/"
+	}
+	ui.Health = ui.health()
+	return ui, nil
+}
+
+// getSparkUIURL is the external url of a driver's spark ui.
+func (c *Controller) getSparkUIURL(driver *corev1.Service) string {
+	cfg := c.getConfig()
+	suffix := cfg.Hostname.Suffix
+	if opts, err := c.exposureOptions(driver); err == nil {
+		suffix = opts.HostSuffix
+	}
+	return "http://" + cfg.sparkUIHost(driver, suffix) + "/"
+}
@@ -2,30 +2,132 @@ package main
 
 import (
 	"flag"
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
 	contourclientset "github.com/heptio/contour/apis/generated/clientset/versioned"
 	contourinformers "github.com/heptio/contour/apis/generated/informers/externalversions"
+	contourinformersv1 "github.com/heptio/contour/apis/generated/informers/externalversions/contour/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
 	"time"
 )
 
 var (
-	masterURL      string
-	kubeconfig     string
-	hostSuffix     string
-	requestTimeout string
+	masterURL         string
+	kubeconfig        string
+	configFile        string
+	hostSuffix        string
+	requestTimeout    string
+	readOnly          bool
+	sourceRanges      string
+	proxyAddr         string
+	proxyMode         string
+	httpAddr          string
+	historyServer     string
+	federationTTL     time.Duration
+	federationWait    time.Duration
+	historyRedirect   bool
+	historyRetention  time.Duration
+	controllerService string
+	snapshotDir       string
+	snapshotInterval  time.Duration
+	sweepInterval     time.Duration
+	sweepMaxDeletions int
+	sweepReportOnly   bool
+	probeInterval     time.Duration
+	probeTimeout      time.Duration
+	probeConcurrency  int
+	probeFailures     int
+	probeSuccesses    int
+	probeExternal     bool
+	dryRun            bool
 )
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	// subcommands run offline or against the cluster and exit, without starting the controller.
+	switch flag.Arg(0) {
+	case "":
+	case "render":
+		os.Exit(runRender(flag.Args()[1:]))
+	case "list":
+		os.Exit(runList(flag.Args()[1:]))
+	case "reconcile":
+		os.Exit(runReconcile(flag.Args()[1:]))
+	case "doctor":
+		os.Exit(runDoctor(flag.Args()[1:]))
+	default:
+		klog.Fatalf("Unknown command %q", flag.Arg(0))
+	}
+
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
+	controller, informerFactory, contourInformerFactory, dynamicInformerFactory := buildController()
+	serviceInformer := informerFactory.Core().V1().Services()
+	endpointsInformer := informerFactory.Core().V1().Endpoints()
+
+	if proxyAddr != "" {
+		proxy, err := NewProxy(controller, proxyMode, serviceInformer, endpointsInformer)
+		if err != nil {
+			klog.Fatalf("Error building spark ui proxy: %s", err.Error())
+		}
+		go func() {
+			klog.Infof("Serving spark ui proxy on %s", proxyAddr)
+			klog.Fatal(http.ListenAndServe(proxyAddr, proxy))
+		}()
+	}
+
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", NewPortal(controller))
+		mux.Handle(apiPrefix, NewAPI(controller))
+		mux.Handle(federatedPath, NewFederation(controller, federationWait, federationTTL))
+		mux.Handle("/metrics", metrics)
+		var handler http.Handler = mux
+		if historyRedirect {
+			handler = NewHistoryRedirector(controller, mux)
+		}
+		go func() {
+			klog.Infof("Serving spark ui portal on %s", httpAddr)
+			klog.Fatal(http.ListenAndServe(httpAddr, handler))
+		}()
+	}
+
+	//notice that there is no need to run Start mothods in a separate goroutine. (i.e. go kubeInformerFactory.Start(
+	// stopCh)
+	//Start method is non-blocking and runs all registered informers in a dedicated goroutine.
+	informerFactory.Start(stopCh)
+	contourInformerFactory.Start(stopCh)
+	dynamicInformerFactory.Start(stopCh)
+
+	if configFile != "" {
+		go wait.Until(controller.watchConfig(configFile), configReloadInterval, stopCh)
+	}
+
+	if err := controller.Run(2, stopCh); err != nil {
+		klog.Fatalf("Error running controller: %s", err.Error())
+	}
+
+}
+
+// buildController builds the controller from the flags, the informer factories are not started.
+func buildController() (*Controller, informers.SharedInformerFactory, contourinformers.SharedInformerFactory,
+	dynamicinformer.DynamicSharedInformerFactory) {
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -36,32 +138,146 @@ func main() {
 	if err != nil {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building dynamic client: %s", err.Error())
+	}
 
 	informerFactory := informers.NewSharedInformerFactory(kubeClient, time.Second*30)
 	serviceInformer := informerFactory.Core().V1().Services()
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	podInformer := informerFactory.Core().V1().Pods()
+	endpointsInformer := informerFactory.Core().V1().Endpoints()
+	networkPolicyInformer := informerFactory.Networking().V1().NetworkPolicies()
 
 	contourInformerFactory := contourinformers.NewSharedInformerFactory(contourClient, time.Second*30)
-	ingressRouteInformer := contourInformerFactory.Contour().V1beta1().IngressRoutes()
+	var ingressRouteInformer contourinformersv1.IngressRouteInformer
+	if servedResources(kubeClient.Discovery(), contourv1.SchemeGroupVersion)["ingressroutes"] {
+		ingressRouteInformer = contourInformerFactory.Contour().V1beta1().IngressRoutes()
+	} else {
+		klog.Warningf("The %s crd is not installed, the spark uis are only served by the built-in proxy "+
+			"until the controller is restarted after it is", contourv1.Resource("ingressroutes"))
+	}
 
-	controller := NewController(hostSuffix, requestTimeout, kubeClient, contourClient, serviceInformer,
-		ingressRouteInformer)
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Second*30)
+	// the crds are optional, the informers of those that are not installed are left out so the
+	// cache sync does not wait for them.
+	served := servedResources(kubeClient.Discovery(), sparkUIEndpointResource.GroupVersion())
+	forCRD := func(resource schema.GroupVersionResource) informers.GenericInformer {
+		if !served[resource.Resource] {
+			klog.Warningf("The %s crd is not installed, the features using it are disabled until the "+
+				"controller is restarted after it is", resource.GroupResource())
+			return nil
+		}
+		return dynamicInformerFactory.ForResource(resource)
+	}
+	policyInformer := forCRD(exposurePolicyResource)
+	clusterPolicyInformer := forCRD(clusterExposurePolicyResource)
+	uiEndpointInformer := forCRD(sparkUIEndpointResource)
 
-	//notice that there is no need to run Start mothods in a separate goroutine. (i.e. go kubeInformerFactory.Start(
-	// stopCh)
-	//Start method is non-blocking and runs all registered informers in a dedicated goroutine.
-	informerFactory.Start(stopCh)
-	contourInformerFactory.Start(stopCh)
+	config, err := loadConfig(configFile)
+	if err != nil {
+		klog.Fatalf("Error loading config: %s", err.Error())
+	}
 
-	if err = controller.Run(2, stopCh); err != nil {
-		klog.Fatalf("Error running controller: %s", err.Error())
+	redirect := HistoryRedirectOptions{
+		Enabled:        historyRedirect,
+		Retention:      historyRetention,
+		ControllerHost: controllerService,
+	}
+	var snapshots *SnapshotStore
+	if snapshotDir != "" {
+		if !historyRedirect {
+			klog.Fatal("-snapshot_dir needs -history_redirect to serve the snapshots")
+		}
+		snapshots, err = NewSnapshotStore(snapshotDir, snapshotInterval, federationWait, historyRetention)
+		if err != nil {
+			klog.Fatalf("Error creating snapshot store: %s", err.Error())
+		}
 	}
+	if historyRedirect {
+		if (historyServer == "" && snapshotDir == "") || httpAddr == "" {
+			klog.Fatal("-history_redirect needs -http_addr and -history_server_url or -snapshot_dir")
+		}
+		_, port, err := net.SplitHostPort(httpAddr)
+		if err != nil {
+			klog.Fatalf("Error parsing -http_addr: %s", err.Error())
+		}
+		if redirect.ControllerPort, err = strconv.Atoi(port); err != nil {
+			klog.Fatalf("Error parsing -http_addr port: %s", err.Error())
+		}
+	}
+
+	controller := NewController(config, historyServer, redirect, snapshots,
+		SweeperOptions{Interval: sweepInterval, MaxDeletions: sweepMaxDeletions, ReportOnly: sweepReportOnly},
+		ProberOptions{Interval: probeInterval, Timeout: probeTimeout, Concurrency: probeConcurrency,
+			FailureThreshold: probeFailures, SuccessThreshold: probeSuccesses, External: probeExternal}, dryRun,
+		kubeClient, contourClient, dynamicClient, serviceInformer, namespaceInformer, podInformer, endpointsInformer,
+		networkPolicyInformer, ingressRouteInformer, policyInformer, clusterPolicyInformer, uiEndpointInformer)
+	return controller, informerFactory, contourInformerFactory, dynamicInformerFactory
+}
 
+// servedResources are the resources of a group version served by the api server.
+func servedResources(client discovery.DiscoveryInterface, gv schema.GroupVersion) map[string]bool {
+	served := map[string]bool{}
+	list, err := client.ServerResourcesForGroupVersion(gv.String())
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Discover the resources of %s failed: %s", gv, err.Error())
+		}
+		return served
+	}
+	for _, resource := range list.APIResources {
+		served[resource.Name] = true
+	}
+	return served
 }
-// 
+
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&configFile, "config", "", "Path to a yaml config file overriding the flags below, it is "+
+		"reloaded when it changes.")
 	flag.StringVar(&hostSuffix, "hostsuffix", ".spark-ui.ushareit.me", "the host suffix ,"+
 		"example .spark-ui.ushareit.org ")
 	flag.StringVar(&requestTimeout, "request_timeout", "60s", "envoy request spark ui timeout.")
+	flag.BoolVar(&readOnly, "read_only", false, "block the spark ui kill endpoints, the built-in proxy also "+
+		"rejects the requests other than GET and HEAD, "+
+		"can be overridden per namespace with the "+readOnlyAnnotation+" annotation.")
+	flag.StringVar(&sourceRanges, "source_ranges", "", "comma separated CIDRs allowed to reach the spark ui, "+
+		"can be overridden per namespace or driver service with the "+sourceRangesAnnotation+" annotation.")
+	flag.StringVar(&proxyAddr, "proxy_addr", "", "address of the built-in spark ui reverse proxy, "+
+		"example :8080, disabled when empty.")
+	flag.StringVar(&proxyMode, "proxy_mode", proxyModeHost, "how the built-in proxy routes requests, "+
+		"host routes <driver><hostsuffix>, path routes /<namespace>/<driver>/.")
+	flag.StringVar(&httpAddr, "http_addr", "", "address of the spark ui portal, e.g. :8081, disabled when empty.")
+	flag.StringVar(&historyServer, "history_server_url", "", "url of the spark history server "+
+		"finished applications are linked to, example http://spark-history:18080.")
+	flag.DurationVar(&federationWait, "federation_timeout", 5*time.Second, "timeout of each driver request "+
+		"made by the federated spark rest api.")
+	flag.DurationVar(&federationTTL, "federation_cache_ttl", 10*time.Second, "how long federated spark rest "+
+		"api results are cached.")
+	flag.BoolVar(&historyRedirect, "history_redirect", false, "redirect the spark ui of a finished "+
+		"application to the history server instead of letting its link break.")
+	flag.DurationVar(&historyRetention, "history_redirect_retention", 7*24*time.Hour, "how long the history "+
+		"server redirect of a finished application is kept.")
+	flag.StringVar(&controllerService, "controller_service", "spark-drive-ui-controller.kube-system.svc.cluster.local",
+		"in cluster host name of the controller, envoy sends history server redirects to it.")
+	flag.StringVar(&snapshotDir, "snapshot_dir", "", "directory, e.g. a PVC, the spark rest api of running "+
+		"drivers is saved to and served from once they terminate, disabled when empty.")
+	flag.DurationVar(&sweepInterval, "sweep_interval", time.Hour, "how often managed services and ingress "+
+		"routes whose driver service is gone are deleted, disabled when 0.")
+	flag.IntVar(&sweepMaxDeletions, "sweep_max_deletions", 50, "maximum number of orphans deleted by a sweep.")
+	flag.BoolVar(&sweepReportOnly, "sweep_report_only", false, "only log and count orphans, do not delete them.")
+	flag.DurationVar(&probeInterval, "probe_interval", 30*time.Second, "how often the spark uis are probed, "+
+		"0 disables the prober.")
+	flag.DurationVar(&probeTimeout, "probe_timeout", 5*time.Second, "timeout of a spark ui probe.")
+	flag.IntVar(&probeConcurrency, "probe_concurrency", 10, "maximum number of spark ui probes in flight.")
+	flag.IntVar(&probeFailures, "probe_failure_threshold", 3, "consecutive failed probes marking a spark ui down.")
+	flag.IntVar(&probeSuccesses, "probe_success_threshold", 1, "consecutive successful probes marking a spark ui up.")
+	flag.BoolVar(&probeExternal, "probe_external", false, "also probe the external url of the spark uis, "+
+		"through the ingress.")
+	flag.BoolVar(&dryRun, "dry_run", false, "log the changes the controller would make to the cluster "+
+		"instead of making them.")
+	flag.DurationVar(&snapshotInterval, "snapshot_interval", time.Minute, "how often running drivers are snapshotted.")
 }
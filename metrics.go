@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// a minimal prometheus text format registry, the controller only needs a handful of
+// counters and gauges and the prometheus client is not worth the dependency tree.
+var metrics = &metricsRegistry{}
+
+var (
+	orphansFound = metrics.newMetric("spark_ui_orphans", "gauge",
+		"Managed objects without their spark driver service found by the last sweep.", "kind")
+	orphansDeletedTotal = metrics.newMetric("spark_ui_orphans_deleted_total", "counter",
+		"Managed objects deleted by the orphan sweeper.", "kind")
+)
+
+type metricsRegistry struct {
+	lock    sync.Mutex
+	metrics []*metric
+}
+
+// metric is a counter or gauge with a fixed set of label names.
+type metric struct {
+	name       string
+	kind       string
+	help       string
+	labelNames []string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func (r *metricsRegistry) newMetric(name, kind, help string, labelNames ...string) *metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	m := &metric{name: name, kind: kind, help: help, labelNames: labelNames, values: map[string]float64{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// key renders the label values as they appear in the exposition format.
+func (m *metric) key(labelValues []string) string {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s expects labels %v, got %v", m.name, m.labelNames, labelValues))
+	}
+	pairs := make([]string, len(labelValues))
+	for i, v := range labelValues {
+		pairs[i] = fmt.Sprintf("%s=%q", m.labelNames[i], v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *metric) add(delta float64, labelValues ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[m.key(labelValues)] += delta
+}
+
+func (m *metric) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metric) set(value float64, labelValues ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[m.key(labelValues)] = value
+}
+
+// delete drops a series, e.g. the gauge of an application that is gone.
+func (m *metric) delete(labelValues ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.values, m.key(labelValues))
+}
+
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range r.metrics {
+		m.lock.Lock()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == "" {
+				fmt.Fprintf(w, "%s %v\n", m.name, m.values[k])
+			} else {
+				fmt.Fprintf(w, "%s{%s} %v\n", m.name, k, m.values[k])
+			}
+		}
+		m.lock.Unlock()
+	}
+}
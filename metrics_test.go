@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	r := &metricsRegistry{}
+	requests := r.newMetric("test_requests_total", "counter", "Test requests.", "code")
+	up := r.newMetric("test_up", "gauge", "Test gauge.")
+	requests.inc("200")
+	requests.add(2, "200")
+	requests.inc("500")
+	up.set(1)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 3
+test_requests_total{code="500"} 1
+# HELP test_up Test gauge.
+# TYPE test_up gauge
+test_up 1
+`
+	if body := w.Body.String(); body != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, body)
+	}
+
+	requests.delete("500")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), `code="500"`) {
+		t.Errorf("deleted series is still exported:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog"
+)
+
+const sparkUINetworkPolicySuffix = "-ui-netpol"
+
+// getSparkUINetworkPolicyName is the name of the network policy of a driver service.
+func getSparkUINetworkPolicyName(driverName string) string {
+	return strings.Replace(driverName, driverServiceSuffix, sparkUINetworkPolicySuffix, 1)
+}
+
+// NewSparkUINetworkPolicy builds the network policy letting the configured peers reach the ui
+// port of a driver pod, and no other port. It is controlled by the driver service like the ui
+// service, so it is garbage collected with the driver.
+func NewSparkUINetworkPolicy(driver *corev1.Service, cfg NetworkPolicyConfig) *networkingv1.NetworkPolicy {
+	protocol := corev1.ProtocolTCP
+	port := intstr.FromInt(sparkUIPort)
+	var from []networkingv1.NetworkPolicyPeer
+	for _, peer := range cfg.From {
+		var p networkingv1.NetworkPolicyPeer
+		if peer.NamespaceSelector != nil {
+			p.NamespaceSelector = &metav1.LabelSelector{MatchLabels: peer.NamespaceSelector}
+		}
+		if peer.PodSelector != nil {
+			p.PodSelector = &metav1.LabelSelector{MatchLabels: peer.PodSelector}
+		}
+		from = append(from, p)
+	}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getSparkUINetworkPolicyName(driver.Name),
+			Namespace: driver.Namespace,
+			Labels:    managedLabels(driver.Name),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(driver, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: driver.Spec.Selector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				From:  from,
+			}},
+		},
+	}
+}
+
+// ensureSparkUINetworkPolicy creates or updates the network policy of a driver when they are
+// enabled, and deletes it when they are not. An unmanaged policy of the same name is left alone.
+func (c *Controller) ensureSparkUINetworkPolicy(driver *corev1.Service) error {
+	cfg := c.getConfig().NetworkPolicy
+	name := getSparkUINetworkPolicyName(driver.Name)
+	existing, err := c.networkPoliciesLister.NetworkPolicies(driver.Namespace).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+	if found && !isManagedBy(existing.ObjectMeta, driver.Name) {
+		if cfg.Enabled {
+			c.warnUnmanagedObject(driver, "networkpolicy", existing.ObjectMeta,
+				"network policy %s exists and is not managed by %s", name, managedByValue)
+		}
+		return nil
+	}
+	if !cfg.Enabled {
+		if !found {
+			return nil
+		}
+		klog.Infof("network policies are disabled, deleting network policy %s/%s", driver.Namespace, name)
+		err := c.deleteNetworkPolicy(driver.Namespace, name)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired := NewSparkUINetworkPolicy(driver, cfg)
+	if !found {
+		klog.Infof("spark ui network policy with name: %s is not found, now create one ...", name)
+		_, err := c.createNetworkPolicy(desired)
+		return err
+	}
+	if equality.Semantic.DeepEqual(existing.Spec, desired.Spec) {
+		return nil
+	}
+	klog.Infof("spark ui network policy with name: %s differs from the desired one, updating it", name)
+	updated := existing.DeepCopy()
+	updated.Spec = desired.Spec
+	_, err = c.updateNetworkPolicy(existing, updated)
+	return err
+}
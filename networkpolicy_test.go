@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func TestEnsureSparkUINetworkPolicy(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	c, _, k8sI := f.newController()
+	cfg := newTestConfig(t)
+	if err := cfg.merge([]byte("networkPolicy:\n  enabled: true\n  from:\n  - namespaceSelector: {name: projectcontour}\n")); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	c.config = cfg
+
+	if err := c.ensureSparkUINetworkPolicy(driverService); err != nil {
+		t.Fatalf("ensure network policy: %v", err)
+	}
+	expected := NewSparkUINetworkPolicy(driverService, cfg.NetworkPolicy)
+	actions := filterInformerActions(f.kubeclient.Actions())
+	if len(actions) != 1 {
+		t.Fatalf("expected a single action, got %+v", actions)
+	}
+	checkAction(clientgotesting.NewCreateAction(schema.GroupVersionResource{Resource: "networkpolicies"},
+		metav1.NamespaceDefault, expected), actions[0], t)
+	if expected.Name != "test-ui-netpol" {
+		t.Errorf("unexpected name %s", expected.Name)
+	}
+	rule := expected.Spec.Ingress[0]
+	if len(rule.Ports) != 1 || rule.Ports[0].Port.IntValue() != sparkUIPort {
+		t.Errorf("expected only the ui port to be open, got %+v", rule.Ports)
+	}
+
+	// disabling the network policies deletes the managed one.
+	k8sI.Networking().V1().NetworkPolicies().Informer().GetIndexer().Add(expected)
+	f.kubeclient.ClearActions()
+	c.config = newTestConfig(t)
+	if err := c.ensureSparkUINetworkPolicy(driverService); err != nil {
+		t.Fatalf("ensure network policy: %v", err)
+	}
+	actions = filterInformerActions(f.kubeclient.Actions())
+	if len(actions) != 1 {
+		t.Fatalf("expected a single action, got %+v", actions)
+	}
+	checkAction(clientgotesting.NewDeleteAction(schema.GroupVersionResource{Resource: "networkpolicies"},
+		metav1.NamespaceDefault, expected.Name), actions[0], t)
+}
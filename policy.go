@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// the exposure policies are custom resources of the controller's own api group, they are read
+// through dynamic informers and converted to the types below.
+var (
+	exposurePolicyResource = schema.GroupVersionResource{
+		Group: "spark-ui.ushareit.com", Version: "v1alpha1", Resource: "sparkuiexposurepolicies",
+	}
+	clusterExposurePolicyResource = schema.GroupVersionResource{
+		Group: "spark-ui.ushareit.com", Version: "v1alpha1", Resource: "clustersparkuiexposurepolicies",
+	}
+)
+
+const (
+	// clusterExposurePolicyName is the name of the ClusterSparkUIExposurePolicy applying to
+	// every namespace, others are ignored.
+	clusterExposurePolicyName = "default"
+	// maxPolicyStatusDrivers bounds the drivers listed in a policy status.
+	maxPolicyStatusDrivers = 100
+	// policyStatusInterval is how often the policy statuses are refreshed.
+	policyStatusInterval = 30 * time.Second
+)
+
+// SparkUIExposurePolicy customizes how the spark uis of a namespace are exposed. The
+// cluster-scoped ClusterSparkUIExposurePolicy named default has the same spec and applies to every
+// namespace, a namespace policy overrides it and annotations override both.
+type SparkUIExposurePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SparkUIExposurePolicySpec   `json:"spec"`
+	Status SparkUIExposurePolicyStatus `json:"status,omitempty"`
+}
+
+// SparkUIExposurePolicySpec fields left empty inherit the value of the less specific level.
+type SparkUIExposurePolicySpec struct {
+	// HostSuffix replaces the hostname suffix of the config.
+	HostSuffix string `json:"hostSuffix,omitempty"`
+	// RequestTimeout replaces the request timeout, a go duration.
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+	// ReadOnly blocks the job and stage kill endpoints, and in the built-in proxy the requests
+	// other than GET and HEAD.
+	ReadOnly *bool `json:"readOnly,omitempty"`
+	// SourceRanges are the CIDRs allowed to reach the spark ui, an empty list allows everyone.
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+	// Auth requires the requests to be authorized by an external service.
+	Auth *SparkUIAuth `json:"auth,omitempty"`
+}
+
+// SparkUIAuth is the external authorization of the requests to the spark uis, like the
+// forward auth of other proxies.
+type SparkUIAuth struct {
+	// URL is asked about every request, with its credentials, a 2xx answer lets it through.
+	// An empty url disables the auth of the less specific level.
+	URL string `json:"url"`
+}
+
+// SparkUIExposurePolicyStatus reports what the controller made of a policy.
+type SparkUIExposurePolicyStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Valid is false when the spec is invalid, the drivers it applies to are then not exposed.
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+	// Drivers counts the driver services the policy applies to, AppliedTo lists the first ones.
+	Drivers   int      `json:"drivers"`
+	AppliedTo []string `json:"appliedTo,omitempty"`
+}
+
+// policyFromObject converts an object of a dynamic informer.
+func policyFromObject(obj interface{}) (*SparkUIExposurePolicy, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	policy := &SparkUIExposurePolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, policy); err != nil {
+		return nil, fmt.Errorf("policy %s: %s", u.GetName(), err.Error())
+	}
+	return policy, nil
+}
+
+// validate checks the spec of a policy.
+func (spec SparkUIExposurePolicySpec) validate() error {
+	if spec.HostSuffix != "" {
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(spec.HostSuffix, ".")); len(errs) > 0 {
+			return fmt.Errorf("hostSuffix %q: %s", spec.HostSuffix, strings.Join(errs, ", "))
+		}
+	}
+	if spec.RequestTimeout != "" {
+		if _, err := time.ParseDuration(spec.RequestTimeout); err != nil {
+			return fmt.Errorf("requestTimeout: %s", err.Error())
+		}
+	}
+	if _, err := parseSourceRanges(strings.Join(spec.SourceRanges, ",")); err != nil {
+		return fmt.Errorf("sourceRanges: %s", err.Error())
+	}
+	if spec.Auth != nil && spec.Auth.URL != "" {
+		u, err := url.Parse(spec.Auth.URL)
+		if err != nil {
+			return fmt.Errorf("auth.url: %s", err.Error())
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("auth.url %q: expected an absolute http or https url", spec.Auth.URL)
+		}
+	}
+	return nil
+}
+
+// apply overrides opts with the fields set in the spec.
+func (spec SparkUIExposurePolicySpec) apply(opts ExposureOptions) (ExposureOptions, error) {
+	if err := spec.validate(); err != nil {
+		return opts, err
+	}
+	if spec.HostSuffix != "" {
+		opts.HostSuffix = spec.HostSuffix
+	}
+	if spec.RequestTimeout != "" {
+		opts.RequestTimeout = spec.RequestTimeout
+	}
+	if spec.ReadOnly != nil {
+		opts.ReadOnly = *spec.ReadOnly
+	}
+	if spec.SourceRanges != nil {
+		opts.SourceRanges, _ = parseSourceRanges(strings.Join(spec.SourceRanges, ","))
+	}
+	if spec.Auth != nil {
+		opts.AuthURL = spec.Auth.URL
+	}
+	return opts, nil
+}
+
+// clusterExposurePolicy returns the cluster default policy, nil when there is none.
+func (c *Controller) clusterExposurePolicy() (*SparkUIExposurePolicy, error) {
+	if c.clusterPoliciesLister == nil {
+		return nil, nil
+	}
+	obj, err := c.clusterPoliciesLister.Get(clusterExposurePolicyName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return policyFromObject(obj)
+}
+
+// namespaceExposurePolicy returns the policy of a namespace, nil when there is none. Only one
+// policy per namespace is supported, the first by name wins and the others are reported invalid.
+func (c *Controller) namespaceExposurePolicy(namespace string) (*SparkUIExposurePolicy, error) {
+	policies, err := c.namespacePolicies(namespace)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	return policies[0], nil
+}
+
+// namespacePolicies returns the policies of a namespace sorted by name.
+func (c *Controller) namespacePolicies(namespace string) ([]*SparkUIExposurePolicy, error) {
+	if c.policiesLister == nil {
+		return nil, nil
+	}
+	objs, err := c.policiesLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var policies []*SparkUIExposurePolicy
+	for _, obj := range objs {
+		policy, err := policyFromObject(obj)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies, nil
+}
+
+// applyExposurePolicies overrides the options of the config with the cluster default policy and
+// the policy of the driver's namespace. An invalid policy is an error, like an invalid annotation.
+func (c *Controller) applyExposurePolicies(opts ExposureOptions, namespace string) (ExposureOptions, error) {
+	cluster, err := c.clusterExposurePolicy()
+	if err != nil {
+		return opts, err
+	}
+	policy, err := c.namespaceExposurePolicy(namespace)
+	if err != nil {
+		return opts, err
+	}
+	return applyPolicies(opts, cluster, policy)
+}
+
+// applyPolicies overrides opts with the cluster default policy and then the namespace policy,
+// either may be nil.
+func applyPolicies(opts ExposureOptions, cluster, policy *SparkUIExposurePolicy) (ExposureOptions, error) {
+	var err error
+	if cluster != nil {
+		if opts, err = cluster.Spec.apply(opts); err != nil {
+			return opts, fmt.Errorf("cluster exposure policy %s: %s", cluster.Name, err.Error())
+		}
+	}
+	if policy != nil {
+		if opts, err = policy.Spec.apply(opts); err != nil {
+			return opts, fmt.Errorf("exposure policy %s/%s: %s", policy.Namespace, policy.Name, err.Error())
+		}
+	}
+	return opts, nil
+}
+
+// policyEventHandler re-enqueues the driver services a policy applies to when it changes.
+func (c *Controller) policyEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		if u.GetNamespace() == "" && u.GetName() != clusterExposurePolicyName {
+			return
+		}
+		c.enqueueDriverServices(u.GetNamespace())
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldU, okOld := oldObj.(*unstructured.Unstructured)
+			newU, okNew := newObj.(*unstructured.Unstructured)
+			// status updates do not change the spec.
+			if okOld && okNew && equality.Semantic.DeepEqual(oldU.Object["spec"], newU.Object["spec"]) {
+				return
+			}
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// enqueueDriverServices adds the driver services of a namespace, all namespaces when empty, to
+// the work queue.
+func (c *Controller) enqueueDriverServices(namespace string) {
+	services, err := c.servicesLister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List services failed: %s", err.Error())
+		return
+	}
+	for _, svc := range services {
+		if c.isSparkDriverService(svc) {
+			c.workqueue.Add(svc.Namespace + "/" + svc.Name)
+		}
+	}
+}
+
+// updateExposurePolicyStatuses writes the validity of every policy and the drivers it applies to.
+func (c *Controller) updateExposurePolicyStatuses() {
+	services, err := c.servicesLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List services failed: %s", err.Error())
+		return
+	}
+	drivers := map[string][]string{}
+	var all []string
+	for _, svc := range services {
+		if c.isSparkDriverService(svc) {
+			drivers[svc.Namespace] = append(drivers[svc.Namespace], svc.Name)
+			all = append(all, svc.Namespace+"/"+svc.Name)
+		}
+	}
+
+	cluster, err := c.clusterExposurePolicy()
+	if err != nil {
+		klog.Errorf("Get cluster exposure policy failed: %s", err.Error())
+	} else if cluster != nil {
+		c.updateExposurePolicyStatus(clusterExposurePolicyResource, cluster, cluster.Spec.validate(), all)
+	}
+
+	if c.policiesLister == nil {
+		return
+	}
+	objs, err := c.policiesLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List exposure policies failed: %s", err.Error())
+		return
+	}
+	for _, obj := range objs {
+		policy, err := policyFromObject(obj)
+		if err != nil {
+			klog.Errorf("Convert exposure policy failed: %s", err.Error())
+			continue
+		}
+		first, _ := c.namespaceExposurePolicy(policy.Namespace)
+		if first != nil && first.Name != policy.Name {
+			err := fmt.Errorf("namespace %s already has exposure policy %s, only one is applied",
+				policy.Namespace, first.Name)
+			c.updateExposurePolicyStatus(exposurePolicyResource, policy, err, nil)
+			continue
+		}
+		c.updateExposurePolicyStatus(exposurePolicyResource, policy, policy.Spec.validate(),
+			drivers[policy.Namespace])
+	}
+}
+
+// updateExposurePolicyStatus writes the status of a policy when it changed.
+func (c *Controller) updateExposurePolicyStatus(resource schema.GroupVersionResource, policy *SparkUIExposurePolicy,
+	invalid error, drivers []string) {
+	status := SparkUIExposurePolicyStatus{
+		ObservedGeneration: policy.Generation,
+		Valid:              invalid == nil,
+		Drivers:            len(drivers),
+	}
+	if invalid != nil {
+		status.Error = invalid.Error()
+	} else {
+		sort.Strings(drivers)
+		if len(drivers) > maxPolicyStatusDrivers {
+			drivers = drivers[:maxPolicyStatusDrivers]
+		}
+		status.AppliedTo = drivers
+	}
+	if equality.Semantic.DeepEqual(status, policy.Status) {
+		return
+	}
+	if err := c.updatePolicyStatus(resource, policy.Namespace, policy.Name, status); err != nil {
+		klog.Errorf("Update status of exposure policy %s/%s failed: %s", policy.Namespace,
+			policy.Name, err.Error())
+	}
+}
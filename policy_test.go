@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	contourfake "github.com/heptio/contour/apis/generated/clientset/versioned/fake"
+	contourinformers "github.com/heptio/contour/apis/generated/informers/externalversions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newExposurePolicy(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	kind := "SparkUIExposurePolicy"
+	if namespace == "" {
+		kind = "ClusterSparkUIExposurePolicy"
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": exposurePolicyResource.GroupVersion().String(),
+		"kind":       kind,
+		"spec":       spec,
+	}}
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func TestExposurePolicySpecValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		spec  SparkUIExposurePolicySpec
+		valid bool
+	}{
+		{"empty", SparkUIExposurePolicySpec{}, true},
+		{"suffix", SparkUIExposurePolicySpec{HostSuffix: ".spark.example.com"}, true},
+		{"invalid suffix", SparkUIExposurePolicySpec{HostSuffix: ".Spark_UI"}, false},
+		{"invalid timeout", SparkUIExposurePolicySpec{RequestTimeout: "1 minute"}, false},
+		{"invalid range", SparkUIExposurePolicySpec{SourceRanges: []string{"10.0.0.0/33"}}, false},
+		{"auth", SparkUIExposurePolicySpec{Auth: &SparkUIAuth{URL: "http://oauth2-proxy.auth.svc/oauth2/auth"}}, true},
+		{"auth disabled", SparkUIExposurePolicySpec{Auth: &SparkUIAuth{}}, true},
+		{"relative auth url", SparkUIExposurePolicySpec{Auth: &SparkUIAuth{URL: "/oauth2/auth"}}, false},
+	} {
+		if err := tc.spec.validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid %v, got %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestNamespaceExposurePolicyOverridesClusterPolicy(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService)
+	f.addReadyDriverPod(driverService)
+	f.policiesLister = append(f.policiesLister,
+		newExposurePolicy("", clusterExposurePolicyName, map[string]interface{}{
+			"requestTimeout": "30s",
+			"readOnly":       true,
+		}),
+		newExposurePolicy(driverService.Namespace, "spark", map[string]interface{}{
+			"hostSuffix": ".spark.example.com",
+			"readOnly":   false,
+		}))
+
+	opts := ExposureOptions{HostSuffix: ".spark.example.com", RequestTimeout: "30s"}
+	expSparkUISvc := NewSparkUIService(driverService, ExposureOptions{})
+	expIngressRoute := NewSparkUIIngressRoute(expSparkUISvc, driverService.Name+".spark.example.com",
+		driverService, opts)
+	f.expectCreateSparkUIServiceAction(expSparkUISvc)
+	f.expectCreateSparkUIIngressRouteAction(expIngressRoute)
+
+	f.run(getKey(driverService, t))
+}
+
+func TestExposurePolicyAuth(t *testing.T) {
+	cluster := &SparkUIExposurePolicy{Spec: SparkUIExposurePolicySpec{
+		Auth: &SparkUIAuth{URL: "http://oauth2-proxy.auth.svc/oauth2/auth"}}}
+	opts, err := applyPolicies(testExposureOptions, cluster, nil)
+	if err != nil || opts.AuthURL != cluster.Spec.Auth.URL {
+		t.Fatalf("expected the auth of the cluster policy, got %q, %v", opts.AuthURL, err)
+	}
+	if unenforceableOptions(opts) == "" {
+		t.Errorf("expected the ingress route of an authorized spark ui to be withheld")
+	}
+	// an empty url in the namespace policy disables the auth of the cluster policy.
+	policy := &SparkUIExposurePolicy{Spec: SparkUIExposurePolicySpec{Auth: &SparkUIAuth{}}}
+	if opts, err = applyPolicies(testExposureOptions, cluster, policy); err != nil || opts.AuthURL != "" {
+		t.Errorf("expected no auth, got %q, %v", opts.AuthURL, err)
+	}
+}
+
+func TestInvalidExposurePolicy(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.svcsobjects = append(f.svcsobjects, driverService)
+	f.policiesLister = append(f.policiesLister, newExposurePolicy(driverService.Namespace, "spark",
+		map[string]interface{}{"requestTimeout": "forever"}))
+
+	f.runExpectError(getKey(driverService, t))
+}
+
+func TestExposurePolicyStatus(t *testing.T) {
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	other := newSparkDriverService("other-driver-svc")
+	other.Namespace = "other"
+	f.svcsLister = append(f.svcsLister, driverService, other)
+	f.policiesLister = append(f.policiesLister,
+		newExposurePolicy(driverService.Namespace, "a", map[string]interface{}{"readOnly": true}),
+		newExposurePolicy(driverService.Namespace, "b", map[string]interface{}{"readOnly": false}),
+		newExposurePolicy("", clusterExposurePolicyName, map[string]interface{}{"requestTimeout": "forever"}))
+	c, _, _ := f.newController()
+
+	c.updateExposurePolicyStatuses()
+
+	for _, tc := range []struct {
+		resource  schema.GroupVersionResource
+		namespace string
+		name      string
+		expected  SparkUIExposurePolicyStatus
+	}{
+		{exposurePolicyResource, driverService.Namespace, "a", SparkUIExposurePolicyStatus{
+			Valid: true, Drivers: 1, AppliedTo: []string{driverService.Name}}},
+		{exposurePolicyResource, driverService.Namespace, "b", SparkUIExposurePolicyStatus{
+			Error: "namespace default already has exposure policy a, only one is applied"}},
+		{clusterExposurePolicyResource, "", clusterExposurePolicyName, SparkUIExposurePolicyStatus{
+			Error: "requestTimeout", Drivers: 2}},
+	} {
+		u, err := f.dynamicclient.Resource(tc.resource).Namespace(tc.namespace).Get(tc.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get policy %s: %v", tc.name, err)
+		}
+		policy, err := policyFromObject(u)
+		if err != nil {
+			t.Fatalf("convert policy %s: %v", tc.name, err)
+		}
+		// the errors are only checked to start with the expected text, they quote go errors.
+		if !strings.HasPrefix(policy.Status.Error, tc.expected.Error) {
+			t.Errorf("policy %s: expected error %q, got %q", tc.name, tc.expected.Error, policy.Status.Error)
+		}
+		policy.Status.Error, tc.expected.Error = "", ""
+		if !reflect.DeepEqual(policy.Status, tc.expected) {
+			t.Errorf("policy %s: expected status %+v, got %+v", tc.name, tc.expected, policy.Status)
+		}
+	}
+}
+
+func TestControllerWithoutCRDs(t *testing.T) {
+	kubeclient := k8sfake.NewSimpleClientset()
+	if served := servedResources(kubeclient.Discovery(), sparkUIEndpointResource.GroupVersion()); len(served) != 0 {
+		t.Errorf("expected no served crd, got %v", served)
+	}
+	kubeclient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: sparkUIEndpointResource.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: sparkUIEndpointResource.Resource}},
+	}}
+	if served := servedResources(kubeclient.Discovery(), sparkUIEndpointResource.GroupVersion()); !served[sparkUIEndpointResource.Resource] ||
+		served[exposurePolicyResource.Resource] {
+		t.Errorf("expected only the sparkuiendpoints to be served, got %v", served)
+	}
+
+	k8sI := informers.NewSharedInformerFactory(kubeclient, noResyncPeriodFunc())
+	contourclient := contourfake.NewSimpleClientset()
+	contourI := contourinformers.NewSharedInformerFactory(contourclient, noResyncPeriodFunc())
+	c := NewController(newTestConfig(t), "", HistoryRedirectOptions{}, nil, SweeperOptions{}, ProberOptions{}, false,
+		kubeclient, contourclient, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		k8sI.Core().V1().Services(), k8sI.Core().V1().Namespaces(), k8sI.Core().V1().Pods(), k8sI.Core().V1().Endpoints(),
+		k8sI.Networking().V1().NetworkPolicies(), contourI.Contour().V1beta1().IngressRoutes(), nil, nil, nil)
+	if !c.policiesSynced() || !c.clusterPoliciesSynced() || !c.uiEndpointsSynced() {
+		t.Errorf("expected the informers of the missing crds to be synced")
+	}
+	driver := newSparkDriverService("test-driver-svc")
+	opts, err := c.applyExposurePolicies(testExposureOptions, driver.Namespace)
+	if err != nil || !reflect.DeepEqual(opts, testExposureOptions) {
+		t.Errorf("expected the config options without policies, got %+v %v", opts, err)
+	}
+	if err := c.syncSparkUIEndpoint(driver); err != nil {
+		t.Errorf("expected no sparkuiendpoint without its crd, got %v", err)
+	}
+	c.updateExposurePolicyStatuses()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+var portalTemplate = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Spark UIs</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 12px; text-align: left; }
+.finished { color: #888; }
+</style>
+</head>
+<body>
+<h1>Spark UIs</h1>
+<form method="get">
+<input name="namespace" placeholder="namespace" value="{{.Namespace}}">
+<input name="selector" placeholder="label selector, e.g. team=data" value="{{.Selector}}" size="40">
+<input type="submit" value="Filter">
+</form>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<table>
+<tr><th>Namespace</th><th>Application</th><th>Driver</th><th>Phase</th><th>Age</th><th>Spark UI</th></tr>
+{{range .UIs}}
+<tr{{if .Finished}} class="finished"{{end}}>
+<td>{{.Namespace}}</td>
+<td>{{.AppName}}</td>
+<td>{{.DriverPod}}</td>
+<td>{{if .Phase}}{{.Phase}}{{else}}Pending{{end}}</td>
+<td>{{.Age}}</td>
+<td>{{if and .Finished .HistoryURL}}<a href="{{.HistoryURL}}">history server</a>{{else}}<a href="{{.URL}}">{{.URL}}</a>{{end}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+// Portal is a web page listing every spark ui managed by the controller, so users
+// do not need kubectl access to find them.
+type Portal struct {
+	controller *Controller
+}
+
+// NewPortal returns a new spark ui portal.
+func NewPortal(controller *Controller) *Portal {
+	return &Portal{controller: controller}
+}
+
+func (p *Portal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		Namespace string
+		Selector  string
+		Error     string
+		UIs       []SparkUI
+	}{
+		Namespace: r.URL.Query().Get("namespace"),
+		Selector:  r.URL.Query().Get("selector"),
+	}
+	selector, err := labels.Parse(data.Selector)
+	if err != nil {
+		data.Error = "invalid label selector: " + err.Error()
+		selector = labels.Nothing()
+	}
+	data.UIs, err = p.controller.listSparkUIs(data.Namespace, selector)
+	if err != nil {
+		klog.Errorf("List spark uis failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := portalTemplate.Execute(w, data); err != nil {
+		klog.Errorf("Render portal failed: %s", err.Error())
+	}
+}
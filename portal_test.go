@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSparkUIFinished(t *testing.T) {
+	tests := []struct {
+		phase    string
+		finished bool
+	}{
+		{phase: "", finished: false},
+		{phase: string(corev1.PodPending), finished: false},
+		{phase: string(corev1.PodRunning), finished: false},
+		{phase: string(corev1.PodSucceeded), finished: true},
+		{phase: string(corev1.PodFailed), finished: true},
+	}
+	for _, test := range tests {
+		if finished := (SparkUI{Phase: test.phase}).Finished(); finished != test.finished {
+			t.Errorf("Finished() of phase %q = %v, expected %v", test.phase, finished, test.finished)
+		}
+	}
+}
+
+func TestPortal(t *testing.T) {
+	f := newFixture(t)
+	running := newSparkDriverService("running-driver-svc")
+	running.Labels = map[string]string{"team": "data"}
+	f.svcsLister = append(f.svcsLister, running)
+	f.addReadyDriverPod(running)
+	// a driver whose pod is not created yet.
+	starting := newSparkDriverService("starting-driver-svc")
+	starting.Spec.Selector = map[string]string{"spark-app-selector": "spark-starting", "spark-role": "driver"}
+	f.svcsLister = append(f.svcsLister, starting)
+	c, _, _ := f.newController()
+	portal := NewPortal(c)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		portal.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := get("/")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "<td>running</td>") ||
+		!strings.Contains(body, "<td>starting</td>") {
+		t.Fatalf("expected both drivers to be listed, got %d %s", w.Code, body)
+	}
+	if strings.Contains(body, `class="finished"`) || !strings.Contains(body, "<td>Pending</td>") {
+		t.Errorf("expected the driver without a pod to be pending, got %s", body)
+	}
+
+	body = get("/?selector=team%3Ddata").Body.String()
+	if !strings.Contains(body, "<td>running</td>") || strings.Contains(body, "<td>starting</td>") {
+		t.Errorf("expected only the selected driver to be listed, got %s", body)
+	}
+
+	body = get("/?selector=team+in+%28").Body.String()
+	if !strings.Contains(body, "invalid label selector") || strings.Contains(body, "<td>running</td>") {
+		t.Errorf("expected an invalid selector to list nothing, got %s", body)
+	}
+
+	if code := get("/other").Code; code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown path, got %d", code)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+// ProberOptions configures the periodic http probes of the spark uis. Probing the ui service
+// and the external url tells a hung driver apart from a broken ingress.
+type ProberOptions struct {
+	// Interval between two probes of every spark ui, the prober is disabled when it is zero.
+	Interval time.Duration
+	// Timeout of a single probe.
+	Timeout time.Duration
+	// Concurrency bounds the probes in flight.
+	Concurrency int
+	// FailureThreshold consecutive failures mark a ui down, SuccessThreshold consecutive
+	// successes mark it up again, so a single slow page does not flap the state.
+	FailureThreshold int
+	SuccessThreshold int
+	// External also probes the external url of the ui, through the ingress.
+	External bool
+}
+
+const (
+	// probeTargetService is the ui service probed from the controller, inside the cluster.
+	probeTargetService = "service"
+	// probeTargetExternal is the external url, through the load balancer and envoy.
+	probeTargetExternal = "external"
+
+	reasonSparkUIUp   = "SparkUIUp"
+	reasonSparkUIDown = "SparkUIDown"
+)
+
+var (
+	probeUp = metrics.newMetric("spark_ui_probe_up", "gauge",
+		"Whether the spark ui answers its probes, after the failure and success thresholds.", "namespace", "app", "target")
+	probeDuration = metrics.newMetric("spark_ui_probe_duration_seconds", "gauge",
+		"Duration of the last probe of the spark ui.", "namespace", "app", "target")
+	probeTransitionsTotal = metrics.newMetric("spark_ui_probe_transitions_total", "counter",
+		"Up and down transitions of the probed spark uis.", "target", "state")
+)
+
+// probeState is the state of one probe target of a driver.
+type probeState struct {
+	// known is false until a threshold was reached once.
+	known     bool
+	up        bool
+	successes int
+	failures  int
+	lastError string
+}
+
+// record counts a probe result and reports whether the state changed.
+func (s *probeState) record(err error, opts ProberOptions) bool {
+	if err == nil {
+		s.successes++
+		s.failures = 0
+		s.lastError = ""
+		if (!s.known || !s.up) && s.successes >= opts.SuccessThreshold {
+			s.known, s.up = true, true
+			return true
+		}
+		return false
+	}
+	s.failures++
+	s.successes = 0
+	s.lastError = err.Error()
+	if (!s.known || s.up) && s.failures >= opts.FailureThreshold {
+		s.known, s.up = true, false
+		return true
+	}
+	return false
+}
+
+// prober holds the probe states, keyed by namespace, application and target.
+type prober struct {
+	opts   ProberOptions
+	client *http.Client
+	// serviceURL is the in-cluster url of a ui service.
+	serviceURL func(uiService *corev1.Service) string
+
+	lock   sync.Mutex
+	states map[string]*probeState
+}
+
+func newProber(opts ProberOptions) *prober {
+	return &prober{
+		opts: opts,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// the history redirect of a finished driver is an answer.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		serviceURL: func(uiService *corev1.Service) string {
+			return fmt.Sprintf("http://%s.%s.svc:%d/", uiService.Name, uiService.Namespace, sparkUIPort)
+		},
+		states: map[string]*probeState{},
+	}
+}
+
+func probeKey(namespace, app, target string) string {
+	return namespace + "/" + app + "/" + target
+}
+
+// record counts a probe result of a target and returns its new state.
+func (p *prober) record(key string, err error) (probeState, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	state, ok := p.states[key]
+	if !ok {
+		state = &probeState{}
+		p.states[key] = state
+	}
+	changed := state.record(err, p.opts)
+	return *state, changed
+}
+
+// state returns the state of a target of a driver, known is false when it was not decided yet.
+func (p *prober) state(driver *corev1.Service, target string) probeState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if state, ok := p.states[probeKey(driver.Namespace, getSparkUIEndpointName(driver.Name), target)]; ok {
+		return *state
+	}
+	return probeState{}
+}
+
+// forget drops the states and metrics of the targets that are no longer probed.
+func (p *prober) forget(probed map[string]bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key := range p.states {
+		if probed[key] {
+			continue
+		}
+		delete(p.states, key)
+		parts := strings.SplitN(key, "/", 3)
+		probeUp.delete(parts...)
+		probeDuration.delete(parts...)
+	}
+}
+
+// get probes url, a server error counts as a failure.
+func (p *prober) get(url string) (time.Duration, error) {
+	start := time.Now()
+	resp, err := p.client.Get(url)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return elapsed, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return elapsed, nil
+}
+
+// probeSparkUIs probes the ui of every running driver once, and its external url when enabled,
+// at most Concurrency at a time.
+func (c *Controller) probeSparkUIs() {
+	services, err := c.servicesLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List services failed: %s", err.Error())
+		return
+	}
+	type probe struct {
+		driver *corev1.Service
+		target string
+		url    string
+	}
+	var probes []probe
+	probed := map[string]bool{}
+	for _, driver := range services {
+		if !c.isSparkDriverService(driver) {
+			continue
+		}
+		pod, err := c.getSparkDriverPod(driver)
+		if err != nil || pod == nil || sparkDriverFinished(pod) {
+			continue
+		}
+		uiService, err := c.servicesLister.Services(driver.Namespace).Get(getSparkUIServiceName(driver.Name))
+		if err != nil {
+			continue
+		}
+		app := getSparkUIEndpointName(driver.Name)
+		probes = append(probes, probe{driver, probeTargetService, c.prober.serviceURL(uiService)})
+		probed[probeKey(driver.Namespace, app, probeTargetService)] = true
+		if !c.prober.opts.External {
+			continue
+		}
+		_, err = c.ingressRoutesLister.IngressRoutes(driver.Namespace).Get(c.getSparkUIIngressRouteName(uiService.Name))
+		if err != nil {
+			// a pending route is not probed, the ui is not published yet.
+			continue
+		}
+		probes = append(probes, probe{driver, probeTargetExternal, c.getSparkUIURL(driver)})
+		probed[probeKey(driver.Namespace, app, probeTargetExternal)] = true
+	}
+	c.prober.forget(probed)
+
+	concurrency := c.prober.opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p probe) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			c.probeSparkUI(p.driver, p.target, p.url)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// probeSparkUI probes a target of a driver, and records an event on the driver service when it
+// goes up or down.
+func (c *Controller) probeSparkUI(driver *corev1.Service, target, url string) {
+	app := getSparkUIEndpointName(driver.Name)
+	elapsed, err := c.prober.get(url)
+	probeDuration.set(elapsed.Seconds(), driver.Namespace, app, target)
+	state, changed := c.prober.record(probeKey(driver.Namespace, app, target), err)
+	if !state.known {
+		return
+	}
+	if state.up {
+		probeUp.set(1, driver.Namespace, app, target)
+	} else {
+		probeUp.set(0, driver.Namespace, app, target)
+	}
+	if !changed {
+		return
+	}
+	if state.up {
+		probeTransitionsTotal.inc(target, "up")
+		klog.Infof("Spark ui %s/%s %s is up", driver.Namespace, app, target)
+		c.recorder.Eventf(driver, corev1.EventTypeNormal, reasonSparkUIUp, "spark ui answers at %s", url)
+		return
+	}
+	probeTransitionsTotal.inc(target, "down")
+	diagnosis := "the driver does not answer on its ui service, it may be hung or overloaded"
+	if target == probeTargetExternal {
+		diagnosis = "the spark ui does not answer at its external url"
+		if service := c.prober.state(driver, probeTargetService); service.known && service.up {
+			diagnosis = "the spark ui answers inside the cluster but not at its external url, the ingress may be broken"
+		}
+	}
+	klog.Warningf("Spark ui %s/%s %s is down: %s", driver.Namespace, app, target, state.lastError)
+	c.recorder.Eventf(driver, corev1.EventTypeWarning, reasonSparkUIDown, "%s: %s", diagnosis, state.lastError)
+}
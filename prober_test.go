@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestProbeStateHysteresis(t *testing.T) {
+	opts := ProberOptions{FailureThreshold: 3, SuccessThreshold: 2}
+	failed := errors.New("connection refused")
+	state := &probeState{}
+	for i, tc := range []struct {
+		err     error
+		changed bool
+		up      bool
+	}{
+		{nil, false, false},
+		{nil, true, true},
+		{failed, false, true},
+		{failed, false, true},
+		{nil, false, true},
+		{failed, false, true},
+		{failed, false, true},
+		{failed, true, false},
+		{nil, false, false},
+		{nil, true, true},
+	} {
+		if changed := state.record(tc.err, opts); changed != tc.changed || state.up != tc.up {
+			t.Errorf("probe %d: expected changed %v up %v, got %v %v", i, tc.changed, tc.up, changed, state.up)
+		}
+	}
+}
+
+func TestProbeSparkUIs(t *testing.T) {
+	var healthy int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "hung", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	f := newFixture(t)
+	driverService := newSparkDriverService("probe-driver-svc")
+	uiService := NewSparkUIService(driverService, ExposureOptions{})
+	f.svcsLister = append(f.svcsLister, driverService, uiService)
+	f.addReadyDriverPod(driverService)
+	c, _, _ := f.newController()
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+	c.prober = newProber(ProberOptions{Timeout: time.Second, Concurrency: 2, FailureThreshold: 2,
+		SuccessThreshold: 1})
+	c.prober.serviceURL = func(*corev1.Service) string { return server.URL }
+	up := func() float64 {
+		probeUp.lock.Lock()
+		defer probeUp.lock.Unlock()
+		return probeUp.values[probeUp.key([]string{metav1.NamespaceDefault, "probe", probeTargetService})]
+	}
+
+	c.probeSparkUIs()
+	if up() != 1 || !c.prober.state(driverService, probeTargetService).up {
+		t.Fatalf("expected the spark ui to be up")
+	}
+	if event := <-recorder.Events; event != "Normal SparkUIUp spark ui answers at "+server.URL {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	c.probeSparkUIs()
+	if up() != 1 {
+		t.Errorf("expected a single failure to keep the spark ui up")
+	}
+	c.probeSparkUIs()
+	if up() != 0 {
+		t.Errorf("expected the spark ui to be down after two failures")
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning SparkUIDown the driver does not answer") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Errorf("expected an event when the spark ui goes down")
+	}
+
+	// the driver finished, its state and gauges are dropped.
+	f.podsLister[0].Status.Phase = corev1.PodSucceeded
+	c.probeSparkUIs()
+	if state := c.prober.state(driverService, probeTargetService); state.known {
+		t.Errorf("expected the state of a finished driver to be dropped, got %+v", state)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformerv1 "k8s.io/client-go/informers/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// proxyModeHost routes the host of a driver's ingress route to its spark ui, like envoy does.
+	proxyModeHost = "host"
+	// proxyModePath routes /<namespace>/<driver name>/ to the driver's spark ui.
+	proxyModePath = "path"
+
+	// serviceNameIndex indexes services by name so a host can be resolved without its namespace.
+	serviceNameIndex = "name"
+	// forwardedPrefixHeader tells the upstream under which prefix it is served in path mode.
+	forwardedPrefixHeader = "X-Forwarded-Prefix"
+	// authTimeout bounds the requests to the external authorization of a policy.
+	authTimeout = 10 * time.Second
+)
+
+// Proxy is a http reverse proxy serving the spark ui services managed by the controller,
+// for clusters that do not run envoy. The routing table comes from the shared informers.
+type Proxy struct {
+	controller      *Controller
+	mode            string
+	servicesIndexer cache.Indexer
+	servicesLister  corelisterv1.ServiceLister
+	endpointsSynced cache.InformerSynced
+	endpointsLister corelisterv1.EndpointsLister
+	transport       http.RoundTripper
+}
+
+// NewProxy returns a new spark ui reverse proxy, it must be called before the informers are started.
+func NewProxy(
+	controller *Controller,
+	mode string,
+	servicesInformer coreinformerv1.ServiceInformer,
+	endpointsInformer coreinformerv1.EndpointsInformer) (*Proxy, error) {
+
+	if mode != proxyModeHost && mode != proxyModePath {
+		return nil, fmt.Errorf("unknown proxy mode %q, expected %s or %s", mode, proxyModeHost, proxyModePath)
+	}
+	err := servicesInformer.Informer().AddIndexers(cache.Indexers{
+		serviceNameIndex: func(obj interface{}) ([]string, error) {
+			svc, ok := obj.(*corev1.Service)
+			if !ok {
+				return nil, nil
+			}
+			return []string{svc.Name}, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Proxy{
+		controller:      controller,
+		mode:            mode,
+		servicesIndexer: servicesInformer.Informer().GetIndexer(),
+		servicesLister:  servicesInformer.Lister(),
+		endpointsSynced: endpointsInformer.Informer().HasSynced,
+		endpointsLister: endpointsInformer.Lister(),
+		transport:       http.DefaultTransport,
+	}, nil
+}
+
+// synced tells whether the caches the proxy routes from have synced. The ingress routes are not
+// among them, the proxy serves the spark uis whether contour is installed or not.
+func (p *Proxy) synced() bool {
+	c := p.controller
+	return c.servicesSynced() && c.podsSynced() && p.endpointsSynced() &&
+		c.namespacesSynced() && c.policiesSynced() && c.clusterPoliciesSynced()
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.synced() {
+		http.Error(w, "spark ui proxy is starting", http.StatusServiceUnavailable)
+		return
+	}
+	driver, prefix, err := p.resolveDriver(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	opts, err := p.controller.exposureOptions(driver)
+	if err != nil {
+		klog.Errorf("Resolve exposure options for service: %s/%s failed: %s", driver.Namespace, driver.Name,
+			err.Error())
+		http.Error(w, "spark ui is misconfigured", http.StatusForbidden)
+		return
+	}
+	if !sourceAllowed(r, opts.SourceRanges) {
+		http.Error(w, "source ip is not allowed", http.StatusForbidden)
+		return
+	}
+	if opts.AuthURL != "" && !p.authorize(w, r, opts.AuthURL) {
+		return
+	}
+	path := cleanPath(strings.TrimPrefix(r.URL.Path, prefix))
+	if opts.ReadOnly {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "spark ui is read only", http.StatusMethodNotAllowed)
+			return
+		}
+		if isKillPath(path) {
+			http.Error(w, "spark ui is read only", http.StatusForbidden)
+			return
+		}
+	}
+	target, err := p.upstream(driver)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), p.requestTimeout(opts))
+	defer cancel()
+	rewriter := &responseRewriter{prefix: prefix, host: r.Host, upstream: target}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			if prefix != "" {
+				req.Header.Set(forwardedPrefixHeader, prefix)
+				rewriter.prepareRequest(req)
+			}
+		},
+		ModifyResponse: rewriter.modifyResponse,
+		Transport:      p.transport,
+	}
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requestTimeout is the timeout of the requests to a spark ui, the one of its exposure options,
+// which a policy may have replaced, or else the configured one.
+func (p *Proxy) requestTimeout(opts ExposureOptions) time.Duration {
+	if timeout, err := time.ParseDuration(opts.RequestTimeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return p.controller.getConfig().requestTimeout()
+}
+
+// cleanPath resolves the dot segments and repeated slashes of a request path, so the path that is
+// checked is the one the spark ui serves. The trailing slash is kept, spark redirects /jobs to
+// /jobs/.
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// resolveDriver finds the driver service a request is for and the path prefix that
+// has to be stripped before forwarding it.
+func (p *Proxy) resolveDriver(r *http.Request) (*corev1.Service, string, error) {
+	if p.mode == proxyModePath {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, "", fmt.Errorf("expected path /<namespace>/<driver service>/")
+		}
+		driver, err := p.servicesLister.Services(parts[0]).Get(parts[1])
+		if err != nil || !p.controller.isSparkDriverService(driver) {
+			return nil, "", fmt.Errorf("spark driver service %s/%s not found", parts[0], parts[1])
+		}
+		return driver, "/" + parts[0] + "/" + parts[1], nil
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	cfg := p.controller.getConfig()
+	// exposed reports whether driver is served on host, its host suffix may come from a policy.
+	exposed := func(driver *corev1.Service) bool {
+		if !cfg.isSparkDriverService(driver) {
+			return false
+		}
+		opts, err := p.controller.exposureOptions(driver)
+		return err == nil && cfg.sparkUIHost(driver, opts.HostSuffix) == host
+	}
+	// with the default hostname template the first label of the host is the driver service name.
+	name := strings.SplitN(host, ".", 2)[0]
+	objs, err := p.servicesIndexer.ByIndex(serviceNameIndex, name)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, obj := range objs {
+		if driver, ok := obj.(*corev1.Service); ok && exposed(driver) {
+			return driver, "", nil
+		}
+	}
+	if cfg.Hostname.Template == defaultHostTemplate {
+		return nil, "", fmt.Errorf("no spark driver service is exposed on %s", host)
+	}
+	// a custom template can not be reversed, look for the driver exposed on host.
+	services, err := p.servicesLister.List(labels.Everything())
+	if err != nil {
+		return nil, "", err
+	}
+	for _, driver := range services {
+		if exposed(driver) {
+			return driver, "", nil
+		}
+	}
+	return nil, "", fmt.Errorf("no spark driver service is exposed on %s", host)
+}
+
+// upstream returns the address of a ready endpoint of the driver's spark ui service.
+func (p *Proxy) upstream(driver *corev1.Service) (*url.URL, error) {
+	name := getSparkUIServiceName(driver.Name)
+	endpoints, err := p.endpointsLister.Endpoints(driver.Namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("spark ui service %s/%s has no endpoints", driver.Namespace, name)
+		}
+		return nil, err
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != sparkUIPortName || len(subset.Addresses) == 0 {
+				continue
+			}
+			return &url.URL{
+				Scheme: "http",
+				Host:   net.JoinHostPort(subset.Addresses[0].IP, strconv.Itoa(int(port.Port))),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("spark ui service %s/%s has no ready endpoints", driver.Namespace, name)
+}
+
+// authorize asks the external authorization of a policy about a request, with its credentials
+// and where it is going. A 2xx answer lets the request through, otherwise the answer is passed on
+// to the client, so a 401 or a redirect to a login page reaches the user.
+func (p *Proxy) authorize(w http.ResponseWriter, r *http.Request, authURL string) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), authTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, authURL, nil)
+	if err != nil {
+		http.Error(w, "spark ui is misconfigured", http.StatusForbidden)
+		return false
+	}
+	for _, header := range []string{"Authorization", "Cookie"} {
+		if v := r.Header.Get(header); v != "" {
+			req.Header.Set(header, v)
+		}
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	resp, err := p.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		klog.Warningf("Authorize request to %s failed: %s", r.Host, err.Error())
+		http.Error(w, "spark ui authorization failed", http.StatusBadGateway)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return true
+	}
+	for _, header := range []string{"Content-Type", "Location", "Set-Cookie", "WWW-Authenticate"} {
+		for _, v := range resp.Header[header] {
+			w.Header().Add(header, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, io.LimitReader(resp.Body, 64<<10))
+	return false
+}
+
+// sourceAllowed checks the client address against the allowlist, the proxy is expected to be
+// reached directly so X-Forwarded-For is not trusted.
+func sourceAllowed(r *http.Request, sourceRanges []string) bool {
+	if len(sourceRanges) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range sourceRanges {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
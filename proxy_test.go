@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newProxyFixture returns a path mode proxy whose only driver, test-driver-svc, has its spark ui
+// served by handler and is exposed with policies.
+func newProxyFixture(t *testing.T, handler http.Handler, policies ...*unstructured.Unstructured) (*Proxy, func()) {
+	upstream := httptest.NewServer(handler)
+	u, _ := url.Parse(upstream.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	f := newFixture(t)
+	f.policiesLister = append(f.policiesLister, policies...)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.addReadyDriverPod(driverService)
+	subset := f.epsLister[0].Subsets[0]
+	subset.Addresses[0].IP = host
+	portNumber, _ := strconv.Atoi(port)
+	subset.Ports[0].Port = int32(portNumber)
+	c, _, k8sI := f.newController()
+	// the name index of NewProxy can not be added to the filled indexer, path mode does not use it.
+	p := &Proxy{
+		controller:      c,
+		mode:            proxyModePath,
+		servicesIndexer: k8sI.Core().V1().Services().Informer().GetIndexer(),
+		servicesLister:  k8sI.Core().V1().Services().Lister(),
+		endpointsSynced: alwaysReady,
+		endpointsLister: k8sI.Core().V1().Endpoints().Lister(),
+		transport:       http.DefaultTransport,
+	}
+	return p, upstream.Close
+}
+
+// serveProxy sends a request for target through the proxy.
+func serveProxy(p *Proxy, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestProxyWaitsForCaches(t *testing.T) {
+	p, stop := newProxyFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer stop()
+
+	p.controller.podsSynced = func() bool { return false }
+	if w := serveProxy(p, http.MethodGet, "/default/test-driver-svc/"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before the pods are synced, got %d", w.Code)
+	}
+
+	// the ingress routes are not needed, the proxy serves without contour.
+	p.controller.podsSynced = alwaysReady
+	p.controller.ingressRoutesSynced = func() bool { return false }
+	if w := serveProxy(p, http.MethodGet, "/default/test-driver-svc/"); w.Code != http.StatusOK {
+		t.Errorf("expected the proxy to serve before the ingress routes are synced, got %d", w.Code)
+	}
+}
+
+func TestProxyPathPrefix(t *testing.T) {
+	p, stop := newProxyFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get(forwardedPrefixHeader) != "/default/test-driver-svc":
+			w.WriteHeader(http.StatusBadRequest)
+		case r.URL.Path == "/":
+			http.Redirect(w, r, "/jobs/", http.StatusFound)
+		case r.URL.Path == "/jobs/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<a href="/stages/">stages</a><script>setUIRoot('')</script>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer stop()
+
+	w := serveProxy(p, http.MethodGet, "/default/test-driver-svc/")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/default/test-driver-svc/jobs/" {
+		t.Errorf("expected the redirect to keep the prefix, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serveProxy(p, http.MethodGet, "/default/test-driver-svc/jobs/")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `href="/default/test-driver-svc/stages/"`) ||
+		!strings.Contains(body, "setUIRoot('/default/test-driver-svc')") {
+		t.Errorf("expected the links of the page to be prefixed, got %d %s", w.Code, body)
+	}
+
+	for _, target := range []string{"/default/", "/default/other-driver-svc/jobs/"} {
+		if w := serveProxy(p, http.MethodGet, target); w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	tests := []struct {
+		remoteAddr   string
+		sourceRanges []string
+		allowed      bool
+	}{
+		{remoteAddr: "192.168.1.7:51234", sourceRanges: nil, allowed: true},
+		{remoteAddr: "192.168.1.7:51234", sourceRanges: []string{"192.168.0.0/16"}, allowed: true},
+		{remoteAddr: "10.1.1.1:51234", sourceRanges: []string{"192.168.0.0/16"}, allowed: false},
+		{remoteAddr: "[fd00::1]:51234", sourceRanges: []string{"10.0.0.0/8", "fd00::/8"}, allowed: true},
+		{remoteAddr: "garbage", sourceRanges: []string{"10.0.0.0/8"}, allowed: false},
+	}
+	for _, test := range tests {
+		r := &http.Request{RemoteAddr: test.remoteAddr}
+		if allowed := sourceAllowed(r, test.sourceRanges); allowed != test.allowed {
+			t.Errorf("sourceAllowed(%s, %v) = %v, expected %v", test.remoteAddr, test.sourceRanges,
+				allowed, test.allowed)
+		}
+	}
+}
+
+func TestProxyAuthorize(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-Host") != "test.spark-ui.example.com" || r.Header.Get("X-Forwarded-Uri") != "/jobs/" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Cookie") != "session=valid" {
+			http.Redirect(w, r, "https://login.example.com/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer auth.Close()
+	p := &Proxy{transport: http.DefaultTransport}
+
+	r := httptest.NewRequest(http.MethodGet, "http://test.spark-ui.example.com/jobs/", nil)
+	r.Header.Set("Cookie", "session=valid")
+	if w := httptest.NewRecorder(); !p.authorize(w, r, auth.URL) {
+		t.Errorf("expected the request to be authorized, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://test.spark-ui.example.com/jobs/", nil)
+	w := httptest.NewRecorder()
+	if p.authorize(w, r, auth.URL) || w.Code != http.StatusFound || w.Header().Get("Location") != "https://login.example.com/" {
+		t.Errorf("expected the redirect to the login page, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	if p.authorize(w, r, "http://127.0.0.1:0/") || w.Code != http.StatusBadGateway {
+		t.Errorf("expected an unreachable authorization to fail, got %d", w.Code)
+	}
+}
+
+func TestProxyReadOnly(t *testing.T) {
+	var forwarded []string
+	p, stop := newProxyFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.Path)
+	}))
+	defer stop()
+	p.controller.config.Security.ReadOnly = true
+
+	tests := []struct {
+		method string
+		target string
+		code   int
+		path   string
+	}{
+		{method: http.MethodGet, target: "/jobs/", code: http.StatusOK, path: "/jobs/"},
+		{method: http.MethodGet, target: "/jobs/job/?id=1", code: http.StatusOK, path: "/jobs/job/"},
+		{method: http.MethodGet, target: "/jobs/job/killed", code: http.StatusOK, path: "/jobs/job/killed"},
+		{method: http.MethodGet, target: "//jobs//job/../job/./", code: http.StatusOK, path: "/jobs/job/"},
+		{method: http.MethodPost, target: "/jobs/", code: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/jobs/job/kill?id=1", code: http.StatusForbidden},
+		{method: http.MethodGet, target: "/jobs/job/kill/?id=1", code: http.StatusForbidden},
+		{method: http.MethodGet, target: "//jobs/job/kill?id=1", code: http.StatusForbidden},
+		{method: http.MethodGet, target: "/jobs/job/./kill?id=1", code: http.StatusForbidden},
+		{method: http.MethodGet, target: "/jobs/x/../job/kill?id=1", code: http.StatusForbidden},
+		{method: http.MethodGet, target: "/stages/./stage/kill?id=1", code: http.StatusForbidden},
+		{method: http.MethodGet, target: "/stages//stage//kill?id=1", code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		forwarded = nil
+		w := serveProxy(p, test.method, "/default/test-driver-svc"+test.target)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.target, test.code, w.Code)
+			continue
+		}
+		if test.path != "" && (len(forwarded) != 1 || forwarded[0] != test.path) {
+			t.Errorf("%s %s: expected %s to be forwarded, got %v", test.method, test.target, test.path, forwarded)
+		}
+		if test.path == "" && len(forwarded) != 0 {
+			t.Errorf("%s %s: expected nothing to be forwarded, got %v", test.method, test.target, forwarded)
+		}
+	}
+}
+
+func TestProxyRequestTimeoutOfPolicy(t *testing.T) {
+	release := make(chan struct{})
+	policy := newExposurePolicy(metav1.NamespaceDefault, "default", map[string]interface{}{"requestTimeout": "50ms"})
+	p, stop := newProxyFixture(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+	}), policy)
+	defer stop()
+	defer close(release)
+
+	start := time.Now()
+	w := serveProxy(p, http.MethodGet, "/default/test-driver-svc/jobs/")
+	if w.Code != http.StatusBadGateway || time.Since(start) > 2*time.Second {
+		t.Errorf("expected the policy timeout to end the request, got %d after %s", w.Code, time.Since(start))
+	}
+}
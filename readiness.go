@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+)
+
+// reasonSparkUIPending is the event recorded on a driver service whose route waits for its ui.
+const reasonSparkUIPending = "SparkUIPending"
+
+// sparkUIPendingReason tells why the spark ui of a driver can not be published yet, it is empty
+// once the driver pod is Ready and the ui service has a ready endpoint on the ui port. A route
+// published before that only returns upstream errors.
+func (c *Controller) sparkUIPendingReason(driver *corev1.Service) (string, error) {
+	pod, err := c.getSparkDriverPod(driver)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pod == nil:
+		return "waiting for the driver pod to be created", nil
+	case pod.Spec.NodeName == "":
+		return "waiting for driver pod " + pod.Name + " to be scheduled", nil
+	case !podReady(pod):
+		return "waiting for driver pod " + pod.Name + " to be Ready", nil
+	}
+	endpoints, err := c.endpointsLister.Endpoints(driver.Namespace).Get(getSparkUIServiceName(driver.Name))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "waiting for the endpoints of the ui service", nil
+		}
+		return "", err
+	}
+	if !hasReadyUIAddress(endpoints) {
+		return "waiting for the ui service to have a ready endpoint on port " + sparkUIPortName, nil
+	}
+	return "", nil
+}
+
+// hasReadyUIAddress reports whether endpoints have a ready address serving the spark ui port.
+func hasReadyUIAddress(endpoints *corev1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) == 0 {
+			continue
+		}
+		for _, port := range subset.Ports {
+			if port.Name == sparkUIPortName || port.Port == sparkUIPort {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// endpointsEventHandler re-enqueues the driver service of a ui service whose endpoints changed,
+// a pending route is published as soon as the ui is ready.
+func (c *Controller) endpointsEventHandler() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		endpoints, ok := obj.(*corev1.Endpoints)
+		if !ok || !strings.HasSuffix(endpoints.Name, sparkUIServiceSuffix) {
+			return
+		}
+		driverName := strings.TrimSuffix(endpoints.Name, sparkUIServiceSuffix) + driverServiceSuffix
+		driver, err := c.servicesLister.Services(endpoints.Namespace).Get(driverName)
+		if err != nil || !c.isSparkDriverService(driver) {
+			return
+		}
+		c.workqueue.Add(driver.Namespace + "/" + driver.Name)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			enqueue(newObj)
+		},
+	}
+}
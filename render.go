@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// renderInput is what the render command reads, only the driver service is required.
+type renderInput struct {
+	driver    *corev1.Service
+	pod       *corev1.Pod
+	namespace *corev1.Namespace
+	// clusterPolicy is the cluster default exposure policy, policies the namespaced ones.
+	clusterPolicy *SparkUIExposurePolicy
+	policies      []*SparkUIExposurePolicy
+}
+
+// runRender implements the render command, it prints the objects the controller would create for
+// a driver service without talking to the cluster:
+//
+//	spark-ui-controller-envoy [flags] render [-f driver.yaml]
+//
+// The input may contain, as separate YAML documents, the driver Service, the driver Pod, the
+// Namespace and the exposure policies, which are taken into account like the controller does.
+// The owner references are left out, the uids they need only exist in the cluster, so the output
+// can be applied but the objects are not garbage collected with the driver.
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	file := fs.String("f", "-", "file with the driver service, - for stdin.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	input, err := readRenderInput(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	objs, err := renderSparkUI(cfg, input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for i, obj := range objs {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(out))
+	}
+	return 0
+}
+
+// readRenderInput decodes the YAML or JSON documents of r.
+func readRenderInput(r io.Reader) (*renderInput, error) {
+	input := &renderInput{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(raw.Raw) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, err
+		}
+		if u.GroupVersionKind().GroupVersion() == exposurePolicyResource.GroupVersion() {
+			policy, err := policyFromObject(u)
+			if err != nil {
+				return nil, err
+			}
+			switch u.GetKind() {
+			case "SparkUIExposurePolicy":
+				input.policies = append(input.policies, policy)
+			case "ClusterSparkUIExposurePolicy":
+				if policy.Name == clusterExposurePolicyName {
+					input.clusterPolicy = policy
+				}
+			default:
+				return nil, fmt.Errorf("unexpected %s, expected an exposure policy", u.GetKind())
+			}
+			continue
+		}
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		switch o := obj.(type) {
+		case *corev1.Service:
+			input.driver = o
+		case *corev1.Pod:
+			input.pod = o
+		case *corev1.Namespace:
+			input.namespace = o
+		default:
+			return nil, fmt.Errorf("unexpected %s, expected a Service, Pod, Namespace or exposure policy",
+				obj.GetObjectKind().GroupVersionKind().Kind)
+		}
+	}
+	if input.driver == nil {
+		return nil, fmt.Errorf("no driver service in input")
+	}
+	if input.driver.Namespace == "" {
+		input.driver.Namespace = "default"
+	}
+	return input, nil
+}
+
+// renderSparkUI builds the objects for the input with the given configuration.
+func renderSparkUI(cfg *Config, input *renderInput) ([]runtime.Object, error) {
+	driver := input.driver
+	if !cfg.isSparkDriverService(driver) {
+		return nil, fmt.Errorf("service %s/%s is not a spark driver service, its name must end with %s, it "+
+			"must select %s and its namespace must be watched", driver.Namespace, driver.Name, driverServiceSuffix,
+			labels.SelectorFromSet(cfg.Detection.Selector))
+	}
+	if input.namespace != nil && input.namespace.Name != driver.Namespace {
+		return nil, fmt.Errorf("namespace %s does not match the driver service namespace %s",
+			input.namespace.Name, driver.Namespace)
+	}
+	if input.pod != nil && !labels.SelectorFromSet(driver.Spec.Selector).Matches(labels.Set(input.pod.Labels)) {
+		fmt.Fprintf(os.Stderr, "warning: driver service %s does not select pod %s\n", driver.Name, input.pod.Name)
+	}
+	// like the controller, the first namespace policy by name wins.
+	var policy *SparkUIExposurePolicy
+	for _, p := range input.policies {
+		if p.Namespace == "" {
+			p.Namespace = driver.Namespace
+		}
+		if p.Namespace == driver.Namespace && (policy == nil || p.Name < policy.Name) {
+			policy = p
+		}
+	}
+	defaults, err := applyPolicies(cfg.exposureOptions(), input.clusterPolicy, policy)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := resolveExposureOptions(defaults, input.namespace, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	uiService := NewSparkUIService(driver, opts)
+	uiService.TypeMeta.APIVersion = "v1"
+	uiService.TypeMeta.Kind = "Service"
+	uiService.OwnerReferences = nil
+	if reason := unenforceableOptions(opts); reason != "" {
+		fmt.Fprintf(os.Stderr, "warning: no ingress route, %s\n", reason)
+		return []runtime.Object{uiService}, nil
+	}
+	route := NewSparkUIIngressRoute(uiService, cfg.sparkUIHost(driver, opts.HostSuffix), driver, opts)
+	route.TypeMeta.APIVersion = contourv1.SchemeGroupVersion.String()
+	route.TypeMeta.Kind = "IngressRoute"
+	route.OwnerReferences = nil
+	return []runtime.Object{uiService, route}, nil
+}
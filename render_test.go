@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	contourv1 "github.com/heptio/contour/apis/contour/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const renderInputTest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: spark
+  annotations:
+    spark-ui.ushareit.com/read-only: "true"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: test-driver-svc
+  namespace: spark
+spec:
+  selector:
+    spark-role: driver
+    spark-app-selector: spark-1234
+  ports:
+  - name: spark-driver-ui-port
+    port: 4040
+`
+
+func TestRenderSparkUI(t *testing.T) {
+	input, err := readRenderInput(strings.NewReader(renderInputTest))
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	if input.namespace == nil || input.pod != nil {
+		t.Fatalf("unexpected input %+v", input)
+	}
+
+	objs, err := renderSparkUI(newTestConfig(t), input)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("expected a service and an ingress route, got %d objects", len(objs))
+	}
+	svc := objs[0].(*corev1.Service)
+	if svc.Name != "test-ui-svc" || svc.Namespace != "spark" || svc.Kind != "Service" {
+		t.Errorf("unexpected service %s/%s of kind %s", svc.Namespace, svc.Name, svc.Kind)
+	}
+	// the namespace is read only, the kill endpoints have routes of their own.
+	if route := objs[1].(*contourv1.IngressRoute); len(route.Spec.Routes) != 3 {
+		t.Errorf("expected the kill endpoints to be blocked, got %+v", route.Spec.Routes)
+	}
+
+	input.namespace.Annotations = nil
+	if objs, err = renderSparkUI(newTestConfig(t), input); err != nil || len(objs) != 2 {
+		t.Fatalf("expected a service and an ingress route, got %d objects, %v", len(objs), err)
+	}
+	route := objs[1].(*contourv1.IngressRoute)
+	if route.Kind != "IngressRoute" || len(route.Spec.Routes) != 1 {
+		t.Errorf("unexpected route %+v", route)
+	}
+	// the uids of the owners are not known outside the cluster.
+	if len(objs[0].(*corev1.Service).OwnerReferences) != 0 || len(route.OwnerReferences) != 0 {
+		t.Errorf("expected no owner references in the rendered objects")
+	}
+}
+
+const renderPoliciesTest = `apiVersion: spark-ui.ushareit.com/v1alpha1
+kind: ClusterSparkUIExposurePolicy
+metadata:
+  name: default
+spec:
+  hostSuffix: .cluster.example.com
+  requestTimeout: 2m
+---
+apiVersion: spark-ui.ushareit.com/v1alpha1
+kind: SparkUIExposurePolicy
+metadata:
+  name: spark
+  namespace: spark
+spec:
+  hostSuffix: .spark.example.com
+---
+apiVersion: spark-ui.ushareit.com/v1alpha1
+kind: SparkUIExposurePolicy
+metadata:
+  name: other
+  namespace: other
+spec:
+  hostSuffix: .other.example.com
+---
+`
+
+func TestRenderAppliesExposurePolicies(t *testing.T) {
+	input, err := readRenderInput(strings.NewReader(renderPoliciesTest + renderInputTest))
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	if input.clusterPolicy == nil || len(input.policies) != 2 {
+		t.Fatalf("expected the cluster policy and 2 namespace policies, got %+v", input)
+	}
+	input.namespace.Annotations = nil
+	objs, err := renderSparkUI(newTestConfig(t), input)
+	if err != nil || len(objs) != 2 {
+		t.Fatalf("expected a service and an ingress route, got %d objects, %v", len(objs), err)
+	}
+	route := objs[1].(*contourv1.IngressRoute)
+	if !strings.HasSuffix(route.Spec.VirtualHost.Fqdn, ".spark.example.com") {
+		t.Errorf("expected the host suffix of the namespace policy, got %s", route.Spec.VirtualHost.Fqdn)
+	}
+	if timeout := route.Spec.Routes[0].TimeoutPolicy; timeout == nil || timeout.Request != "2m" {
+		t.Errorf("expected the request timeout of the cluster policy, got %+v", timeout)
+	}
+}
+
+func TestRenderRequiresDriverService(t *testing.T) {
+	_, err := readRenderInput(strings.NewReader("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: spark\n"))
+	if err == nil {
+		t.Errorf("expected an error without a driver service")
+	}
+}
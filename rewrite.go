@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// root relative href, src and action attributes in spark ui pages.
+	htmlAttributeRegexp = regexp.MustCompile(`(\s(?:href|src|action)\s*=\s*["'])(/[^"']*)`)
+	// spark ui pages tell their scripts where the ui lives with setUIRoot('...'), the
+	// scripts build the rest api urls from it.
+	uiRootRegexp = regexp.MustCompile(`setUIRoot\('([^']*)'\)`)
+	// scripts that do not use the ui root build the rest api urls from the origin,
+	// e.g. location.origin + "/api/v1/applications".
+	locationOriginRegexp = regexp.MustCompile(`(location\.origin\s*\+\s*["'])(/[^"']*)`)
+)
+
+// responseRewriter makes a spark ui work when it is served under a path prefix or a host
+// it does not know about, without the driver being configured with spark.ui.proxyBase.
+// Links that already carry the prefix, because proxyBase is set, are left alone.
+type responseRewriter struct {
+	// prefix the spark ui is served under, empty when routing by host.
+	prefix string
+	// host the client used to reach the proxy.
+	host string
+	// upstream is the address of the spark ui the request was forwarded to.
+	upstream *url.URL
+}
+
+// prepareRequest asks the upstream for an uncompressed response so its body can be rewritten.
+func (rw *responseRewriter) prepareRequest(req *http.Request) {
+	req.Header.Del("Accept-Encoding")
+}
+
+// modifyResponse is used as httputil.ReverseProxy.ModifyResponse.
+func (rw *responseRewriter) modifyResponse(resp *http.Response) error {
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", rw.rewriteLocation(location))
+	}
+	if rw.prefix == "" || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	var rewrite func([]byte) []byte
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/html":
+		rewrite = rw.rewriteHTML
+	case "application/javascript", "text/javascript", "application/x-javascript":
+		rewrite = rw.rewriteJavaScript
+	default:
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	body = rewrite(body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Del("ETag")
+	return nil
+}
+
+// rewriteLocation maps a redirect from the spark ui back to the address the client used.
+func (rw *responseRewriter) rewriteLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.IsAbs() {
+		if u.Host != rw.upstream.Host && u.Host != rw.host {
+			// a redirect to somewhere else entirely, e.g. the history server.
+			return location
+		}
+		u.Scheme = ""
+		u.Host = ""
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		u.Path = rw.prefixPath(u.Path)
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+func (rw *responseRewriter) rewriteHTML(body []byte) []byte {
+	body = htmlAttributeRegexp.ReplaceAllFunc(body, func(match []byte) []byte {
+		parts := htmlAttributeRegexp.FindSubmatch(match)
+		return append(append([]byte{}, parts[1]...), rw.prefixPath(string(parts[2]))...)
+	})
+	return uiRootRegexp.ReplaceAllFunc(body, func(match []byte) []byte {
+		root := string(uiRootRegexp.FindSubmatch(match)[1])
+		if root == "" {
+			return []byte("setUIRoot('" + rw.prefix + "')")
+		}
+		return []byte("setUIRoot('" + rw.prefixPath(root) + "')")
+	})
+}
+
+func (rw *responseRewriter) rewriteJavaScript(body []byte) []byte {
+	return locationOriginRegexp.ReplaceAllFunc(body, func(match []byte) []byte {
+		parts := locationOriginRegexp.FindSubmatch(match)
+		return append(append([]byte{}, parts[1]...), rw.prefixPath(string(parts[2]))...)
+	})
+}
+
+// prefixPath adds the prefix to a root relative path, unless it is protocol relative or
+// already prefixed.
+func (rw *responseRewriter) prefixPath(path string) string {
+	if rw.prefix == "" || strings.HasPrefix(path, "//") ||
+		path == rw.prefix || strings.HasPrefix(path, rw.prefix+"/") {
+		return path
+	}
+	return rw.prefix + path
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func newTestRewriter(prefix string) *responseRewriter {
+	return &responseRewriter{
+		prefix:   prefix,
+		host:     "proxy.example.com",
+		upstream: &url.URL{Scheme: "http", Host: "10.0.0.5:4040"},
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		location string
+		expected string
+	}{
+		{prefix: "/ns/app-driver-svc", location: "/jobs/", expected: "/ns/app-driver-svc/jobs/"},
+		{prefix: "/ns/app-driver-svc", location: "http://10.0.0.5:4040/jobs/?id=1", expected: "/ns/app-driver-svc/jobs/?id=1"},
+		{prefix: "/ns/app-driver-svc", location: "http://proxy.example.com/stages/", expected: "/ns/app-driver-svc/stages/"},
+		{prefix: "/ns/app-driver-svc", location: "/ns/app-driver-svc/jobs/", expected: "/ns/app-driver-svc/jobs/"},
+		{prefix: "/ns/app-driver-svc", location: "http://history:18080/history/app-1", expected: "http://history:18080/history/app-1"},
+		{prefix: "", location: "http://10.0.0.5:4040/jobs/", expected: "/jobs/"},
+	}
+	for _, test := range tests {
+		if location := newTestRewriter(test.prefix).rewriteLocation(test.location); location != test.expected {
+			t.Errorf("rewriteLocation(%s) = %s, expected %s", test.location, location, test.expected)
+		}
+	}
+}
+
+func TestRewriteHTML(t *testing.T) {
+	rw := newTestRewriter("/ns/app-driver-svc")
+	body := `<link href="/static/bootstrap.min.css"/><script src="//cdn.example.com/x.js"></script>` +
+		`<a href="/ns/app-driver-svc/jobs/">Jobs</a><script>setUIRoot('')</script>`
+	expected := `<link href="/ns/app-driver-svc/static/bootstrap.min.css"/><script src="//cdn.example.com/x.js"></script>` +
+		`<a href="/ns/app-driver-svc/jobs/">Jobs</a><script>setUIRoot('/ns/app-driver-svc')</script>`
+	if rewritten := string(rw.rewriteHTML([]byte(body))); rewritten != expected {
+		t.Errorf("expected\n\t%s\ngot\n\t%s", expected, rewritten)
+	}
+}
+
+func TestRewriteJavaScript(t *testing.T) {
+	rw := newTestRewriter("/ns/app-driver-svc")
+	body := `return location.origin + "/api/v1/applications/" + appId + "/allexecutors";`
+	expected := `return location.origin + "/ns/app-driver-svc/api/v1/applications/" + appId + "/allexecutors";`
+	if rewritten := string(rw.rewriteJavaScript([]byte(body))); rewritten != expected {
+		t.Errorf("expected\n\t%s\ngot\n\t%s", expected, rewritten)
+	}
+}
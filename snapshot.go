@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+// spark rest api resources saved in a snapshot, by file name.
+var snapshotResources = map[string]func(appID string) string{
+	"application": func(appID string) string { return "/api/v1/applications/" + appID },
+	"jobs":        func(appID string) string { return "/api/v1/applications/" + appID + "/jobs" },
+	"stages":      func(appID string) string { return "/api/v1/applications/" + appID + "/stages" },
+	"executors":   func(appID string) string { return "/api/v1/applications/" + appID + "/allexecutors" },
+	"sql":         func(appID string) string { return "/api/v1/applications/" + appID + "/sql" },
+	"environment": func(appID string) string { return "/api/v1/applications/" + appID + "/environment" },
+}
+
+// environment properties whose values are redacted, the same as spark.redaction.regex plus
+// the usual cloud credential names.
+var redactionRegexp = regexp.MustCompile(`(?i)secret|password|token|credential|access[._-]?key`)
+
+const redactedValue = "*********(redacted)"
+
+var snapshotIndexTemplate = template.Must(template.New("snapshot").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.AppID}} snapshot</title></head>
+<body>
+<h1>{{.AppID}}</h1>
+<p>The driver of this application has terminated. This is a read only snapshot of its Spark REST API taken at {{.Taken}}.</p>
+<ul>
+{{range .Resources}}<li><a href="{{index $.Paths .}}">{{.}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// SnapshotStore saves the spark rest api of running drivers to disk, so the data of
+// applications that do not write event logs is still available after the driver exits.
+// Snapshots are stored as <dir>/<namespace>/<app id>/<resource>.json.
+type SnapshotStore struct {
+	dir       string
+	client    *http.Client
+	interval  time.Duration
+	retention time.Duration
+	// serviceURL is the address of a spark ui service, the tests point it at a fake driver.
+	serviceURL func(namespace, uiService string) string
+}
+
+// NewSnapshotStore returns a new snapshot store writing to dir, running drivers are
+// snapshotted every interval and snapshots are kept for retention.
+func NewSnapshotStore(dir string, interval, timeout, retention time.Duration) (*SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{
+		dir:        dir,
+		client:     &http.Client{Timeout: timeout},
+		interval:   interval,
+		retention:  retention,
+		serviceURL: sparkUIServiceURL,
+	}, nil
+}
+
+// snapshotRunningDrivers refreshes the snapshot of every running driver. The ui stops with the
+// application, so the last periodic snapshot is what is left when a driver completes.
+func (c *Controller) snapshotRunningDrivers() {
+	uis, err := c.listSparkUIs("", labels.Everything())
+	if err != nil {
+		klog.Errorf("List spark uis failed: %s", err.Error())
+		return
+	}
+	for _, ui := range uis {
+		if ui.Finished() || ui.UIService == "" || ui.AppID == "" {
+			continue
+		}
+		if err := c.snapshots.take(ui.Namespace, ui.UIService, ui.AppID); err != nil {
+			klog.Warningf("Snapshot spark ui of %s/%s failed: %s", ui.Namespace, ui.DriverService, err.Error())
+		}
+	}
+	c.snapshots.expire()
+}
+
+// takeFinalSnapshot tries to snapshot a driver whose pod is terminating, its ui may still
+// answer during the termination grace period.
+func (c *Controller) takeFinalSnapshot(driver *corev1.Service) {
+	appID := driver.Spec.Selector[sparkAppSelectorLabel]
+	if appID == "" {
+		return
+	}
+	err := c.snapshots.take(driver.Namespace, getSparkUIServiceName(driver.Name), appID)
+	if err != nil {
+		klog.Infof("Final snapshot of %s/%s failed, keeping the previous one: %s", driver.Namespace,
+			driver.Name, err.Error())
+	}
+}
+
+// take saves the spark rest api of an application through its ui service. Every resource is
+// fetched before anything is written, so a failure keeps the previous snapshot intact.
+func (s *SnapshotStore) take(namespace, uiService, appID string) error {
+	data := map[string][]byte{}
+	for name, path := range snapshotResources {
+		body, err := s.fetch(s.serviceURL(namespace, uiService) + path(appID))
+		if err != nil {
+			if name == "sql" {
+				// only applications using spark sql have this endpoint.
+				continue
+			}
+			return err
+		}
+		if name == "environment" {
+			if body, err = redactEnvironment(body); err != nil {
+				return err
+			}
+		}
+		data[name] = body
+	}
+
+	dir := s.appDir(namespace, appID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, body := range data {
+		tmp := filepath.Join(dir, "."+name+".json.tmp")
+		if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name+".json")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SnapshotStore) fetch(url string) ([]byte, error) {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, federationMaxResponse))
+}
+
+// expire removes the snapshots older than the retention period.
+func (s *SnapshotStore) expire() {
+	namespaces, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		klog.Errorf("Read snapshot dir failed: %s", err.Error())
+		return
+	}
+	for _, ns := range namespaces {
+		apps, err := ioutil.ReadDir(filepath.Join(s.dir, ns.Name()))
+		if err != nil {
+			continue
+		}
+		for _, app := range apps {
+			if time.Since(app.ModTime()) > s.retention {
+				klog.Infof("Snapshot of %s/%s expired, deleting it", ns.Name(), app.Name())
+				if err := os.RemoveAll(filepath.Join(s.dir, ns.Name(), app.Name())); err != nil {
+					klog.Errorf("Delete snapshot failed: %s", err.Error())
+				}
+			}
+		}
+	}
+}
+
+func (s *SnapshotStore) appDir(namespace, appID string) string {
+	return filepath.Join(s.dir, filepath.Base(namespace), filepath.Base(appID))
+}
+
+// has reports whether there is a snapshot of an application.
+func (s *SnapshotStore) has(namespace, appID string) bool {
+	_, err := os.Stat(filepath.Join(s.appDir(namespace, appID), "application.json"))
+	return err == nil
+}
+
+// serve answers a request for the ui of a finished application from its snapshot, "/" lists
+// the saved resources and the spark rest api paths return the saved json.
+func (s *SnapshotStore) serve(w http.ResponseWriter, r *http.Request, namespace, appID string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "snapshot is read only", http.StatusMethodNotAllowed)
+		return
+	}
+	dir := s.appDir(namespace, appID)
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "" {
+		info, err := os.Stat(filepath.Join(dir, "application.json"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			AppID     string
+			Taken     time.Time
+			Resources []string
+			Paths     map[string]string
+		}{AppID: appID, Taken: info.ModTime(), Paths: map[string]string{}}
+		for name, p := range snapshotResources {
+			if _, err := os.Stat(filepath.Join(dir, name+".json")); err == nil {
+				data.Resources = append(data.Resources, name)
+				data.Paths[name] = p(appID)
+			}
+		}
+		sort.Strings(data.Resources)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := snapshotIndexTemplate.Execute(w, data); err != nil {
+			klog.Errorf("Render snapshot index failed: %s", err.Error())
+		}
+		return
+	}
+	if path == "/api/v1/applications" {
+		body, err := ioutil.ReadFile(filepath.Join(dir, "application.json"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "[%s]", body)
+		return
+	}
+	for name, p := range snapshotResources {
+		if path == p(appID) {
+			w.Header().Set("Content-Type", "application/json")
+			http.ServeFile(w, r, filepath.Join(dir, name+".json"))
+			return
+		}
+	}
+	http.Error(w, "not part of the snapshot, the driver of "+appID+" has terminated", http.StatusNotFound)
+}
+
+// redactEnvironment blanks the values of secret looking properties in the spark rest api
+// environment, which lists properties as [name, value] pairs.
+func redactEnvironment(body []byte) ([]byte, error) {
+	var env map[string]interface{}
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("decode environment: %s", err.Error())
+	}
+	for _, props := range env {
+		list, ok := props.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, prop := range list {
+			pair, ok := prop.([]interface{})
+			if !ok || len(pair) != 2 {
+				continue
+			}
+			if name, ok := pair[0].(string); ok && redactionRegexp.MatchString(name) {
+				pair[1] = redactedValue
+			}
+		}
+	}
+	return json.Marshal(env)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedactEnvironment(t *testing.T) {
+	body := []byte(`{
+		"runtime": {"javaVersion": "1.8.0"},
+		"sparkProperties": [["spark.app.name", "test"], ["spark.hadoop.fs.s3a.secret.key", "s3cr3t"]],
+		"hadoopProperties": [["fs.s3a.access.key", "AKIA"], ["fs.defaultFS", "hdfs://nn"]],
+		"systemProperties": [["javax.net.ssl.trustStorePassword", "changeit"]]
+	}`)
+	redacted, err := redactEnvironment(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var env map[string]interface{}
+	if err := json.Unmarshal(redacted, &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"runtime": map[string]interface{}{"javaVersion": "1.8.0"},
+		"sparkProperties": []interface{}{
+			[]interface{}{"spark.app.name", "test"},
+			[]interface{}{"spark.hadoop.fs.s3a.secret.key", redactedValue},
+		},
+		"hadoopProperties": []interface{}{
+			[]interface{}{"fs.s3a.access.key", redactedValue},
+			[]interface{}{"fs.defaultFS", "hdfs://nn"},
+		},
+		"systemProperties": []interface{}{
+			[]interface{}{"javax.net.ssl.trustStorePassword", redactedValue},
+		},
+	}
+	if !reflect.DeepEqual(expected, env) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+}
+
+// sparkAPIHandler answers the spark rest api of the spark-test application, without the sql
+// endpoint, and counts the requests.
+func sparkAPIHandler(hits *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		switch strings.TrimPrefix(r.URL.Path, "/api/v1/applications/spark-test") {
+		case "":
+			w.Write([]byte(`{"id": "spark-test", "name": "test"}`))
+		case "/jobs", "/stages", "/allexecutors":
+			w.Write([]byte(`[]`))
+		case "/environment":
+			w.Write([]byte(`{"sparkProperties": [["spark.authenticate.secret", "s3cr3t"]]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// newSnapshotStore returns a store in a temporary directory taking its snapshots from handler.
+func newSnapshotStore(t *testing.T, handler http.Handler) (*SnapshotStore, func()) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatalf("create snapshot dir: %v", err)
+	}
+	store, err := NewSnapshotStore(dir, time.Minute, time.Second, time.Hour)
+	if err != nil {
+		t.Fatalf("create snapshot store: %v", err)
+	}
+	driver := httptest.NewServer(handler)
+	store.serviceURL = func(namespace, uiService string) string {
+		return driver.URL
+	}
+	return store, func() {
+		driver.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTakesFinalSnapshotOfTerminatingDriver(t *testing.T) {
+	var hits int32
+	store, stop := newSnapshotStore(t, sparkAPIHandler(&hits))
+	defer stop()
+	f := newFixture(t)
+	driverService := newSparkDriverService("test-driver-svc")
+	f.svcsLister = append(f.svcsLister, driverService)
+	f.addReadyDriverPod(driverService)
+	deleted := metav1.Now()
+	f.podsLister[0].DeletionTimestamp = &deleted
+	c, _, _ := f.newController()
+	c.historyRedirect = HistoryRedirectOptions{Enabled: true, Retention: time.Hour}
+	c.snapshots = store
+
+	if err := c.syncHandler(getKey(driverService, t)); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+
+	if !store.has(metav1.NamespaceDefault, "spark-test") {
+		t.Fatalf("expected a snapshot of spark-test after %d requests", atomic.LoadInt32(&hits))
+	}
+	dir := store.appDir(metav1.NamespaceDefault, "spark-test")
+	if _, err := os.Stat(filepath.Join(dir, "sql.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no sql snapshot without the sql endpoint, got %v", err)
+	}
+	env, err := ioutil.ReadFile(filepath.Join(dir, "environment.json"))
+	if err != nil || strings.Contains(string(env), "s3cr3t") {
+		t.Errorf("expected a redacted environment, got %s, %v", env, err)
+	}
+
+	// a running driver is left to the periodic snapshots.
+	f.podsLister[0].DeletionTimestamp = nil
+	os.RemoveAll(dir)
+	if err := c.syncHandler(getKey(driverService, t)); err != nil {
+		t.Fatalf("error syncing service: %v", err)
+	}
+	if store.has(metav1.NamespaceDefault, "spark-test") {
+		t.Error("expected no final snapshot of a running driver")
+	}
+}
+
+func TestSnapshotServe(t *testing.T) {
+	var hits int32
+	store, stop := newSnapshotStore(t, sparkAPIHandler(&hits))
+	defer stop()
+	if err := store.take(metav1.NamespaceDefault, "test-ui-svc", "spark-test"); err != nil {
+		t.Fatalf("take snapshot: %v", err)
+	}
+	get := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		store.serve(w, httptest.NewRequest(method, url, nil), metav1.NamespaceDefault, "spark-test")
+		return w
+	}
+
+	w := get(http.MethodGet, "/")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `href="/api/v1/applications/spark-test/jobs"`) ||
+		strings.Contains(body, ">sql<") {
+		t.Errorf("expected an index of the saved resources, got %d %s", w.Code, body)
+	}
+
+	w = get(http.MethodGet, "/api/v1/applications")
+	var apps []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &apps); err != nil || len(apps) != 1 || apps[0]["id"] != "spark-test" {
+		t.Errorf("expected the list of applications, got %d %s", w.Code, w.Body.String())
+	}
+	w = get(http.MethodGet, "/api/v1/applications/spark-test/jobs")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" ||
+		strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("expected the saved jobs, got %d %q %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	if code := get(http.MethodGet, "/jobs/").Code; code != http.StatusNotFound {
+		t.Errorf("expected 404 for a page that is not saved, got %d", code)
+	}
+	if code := get(http.MethodPost, "/api/v1/applications/spark-test/jobs").Code; code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for a POST, got %d", code)
+	}
+	w = httptest.NewRecorder()
+	store.serve(w, httptest.NewRequest(http.MethodGet, "/", nil), metav1.NamespaceDefault, "spark-other")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an application without a snapshot, got %d", w.Code)
+	}
+}
+
+func TestSnapshotExpire(t *testing.T) {
+	store, stop := newSnapshotStore(t, http.NotFoundHandler())
+	defer stop()
+	for _, appID := range []string{"spark-expired", "spark-retained"} {
+		if err := os.MkdirAll(store.appDir(metav1.NamespaceDefault, appID), 0755); err != nil {
+			t.Fatalf("create snapshot: %v", err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(store.appDir(metav1.NamespaceDefault, "spark-expired"), old, old); err != nil {
+		t.Fatalf("age snapshot: %v", err)
+	}
+
+	store.expire()
+
+	if _, err := os.Stat(store.appDir(metav1.NamespaceDefault, "spark-expired")); !os.IsNotExist(err) {
+		t.Errorf("expected the expired snapshot to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(store.appDir(metav1.NamespaceDefault, "spark-retained")); err != nil {
+		t.Errorf("expected the recent snapshot to be kept, got %v", err)
+	}
+}
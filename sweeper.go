@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// managedByLabel and managedByValue mark every object created by the controller.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "spark-ui-controller"
+	// driverServiceLabel on a managed object is the name of the driver service it was created for.
+	driverServiceLabel = annotationPrefix + "driver-service"
+	// adoptAnnotation set to "true" on an unmanaged object lets the controller take it over, for
+	// objects created by versions that did not label them.
+	adoptAnnotation = annotationPrefix + "adopt"
+
+	reasonUnmanagedObjectExists = "UnmanagedObjectExists"
+	reasonAdopted               = "Adopted"
+)
+
+// SweeperOptions configures the periodic deletion of managed objects whose driver service is gone.
+type SweeperOptions struct {
+	// Interval between two sweeps, the sweeper is disabled when it is zero.
+	Interval time.Duration
+	// MaxDeletions bounds the objects deleted by a sweep, so a bug or an empty cache can not
+	// wipe every spark ui at once.
+	MaxDeletions int
+	// ReportOnly logs and counts the orphans without deleting them.
+	ReportOnly bool
+}
+
+// managedLabels are the labels of the objects created for a driver service.
+func managedLabels(driverName string) map[string]string {
+	return map[string]string{
+		managedByLabel:     managedByValue,
+		driverServiceLabel: driverName,
+	}
+}
+
+// isManagedBy reports whether an object was created by the controller for a driver service.
+func isManagedBy(meta metav1.ObjectMeta, driverName string) bool {
+	return meta.Labels[managedByLabel] == managedByValue && meta.Labels[driverServiceLabel] == driverName
+}
+
+// adoptionRequested reports whether an unmanaged object may be taken over by the controller.
+func adoptionRequested(meta metav1.ObjectMeta) bool {
+	adopt, _ := strconv.ParseBool(meta.Annotations[adoptAnnotation])
+	return adopt
+}
+
+// warnUnmanagedObject records a warning on the driver about an unmanaged object standing in the
+// way of one of its objects. The warning is recorded when the object is first seen or when it
+// changes, not on every resync.
+func (c *Controller) warnUnmanagedObject(driver *corev1.Service, kind string, meta metav1.ObjectMeta,
+	messageFmt string, args ...interface{}) {
+	key := driver.Namespace + "/" + driver.Name + "/" + kind + "/" + meta.Name
+	c.unmanagedLock.Lock()
+	seen, ok := c.unmanagedObjects[key]
+	c.unmanagedObjects[key] = meta.ResourceVersion
+	c.unmanagedLock.Unlock()
+	if ok && seen == meta.ResourceVersion {
+		return
+	}
+	c.recorder.Eventf(driver, corev1.EventTypeWarning, reasonUnmanagedObjectExists, messageFmt, args...)
+}
+
+// forgetUnmanagedObjects forgets the unmanaged objects warned about for a driver service.
+func (c *Controller) forgetUnmanagedObjects(namespace, driverName string) {
+	prefix := namespace + "/" + driverName + "/"
+	c.unmanagedLock.Lock()
+	defer c.unmanagedLock.Unlock()
+	for key := range c.unmanagedObjects {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.unmanagedObjects, key)
+		}
+	}
+}
+
+// adoptObjectMeta stamps an adopted object with the labels and owner of the object the controller
+// would have created, and removes the adoption annotation.
+func adoptObjectMeta(meta *metav1.ObjectMeta, desired metav1.ObjectMeta) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	for k, v := range desired.Labels {
+		meta.Labels[k] = v
+	}
+	delete(meta.Annotations, adoptAnnotation)
+	meta.OwnerReferences = desired.OwnerReferences
+}
+
+// managedSelector selects every object created by the controller.
+func managedSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{managedByLabel: managedByValue})
+}
+
+// sweepOrphans deletes the managed spark ui services and ingress routes whose driver service no
+// longer exists. Routes redirecting to the history server are left to expireHistoryRoutes.
+func (c *Controller) sweepOrphans() {
+	deletions := 0
+	found := map[string]int{"service": 0, "ingressroute": 0}
+	defer func() {
+		for kind, n := range found {
+			orphansFound.set(float64(n), kind)
+		}
+	}()
+	// sweep reports whether an orphan may be deleted, counting it against the limit.
+	sweep := func(kind, namespace, name, driver string) bool {
+		if driver == "" {
+			return false
+		}
+		_, err := c.servicesLister.Services(namespace).Get(driver)
+		if err == nil || !errors.IsNotFound(err) {
+			return false
+		}
+		found[kind]++
+		if c.sweeper.ReportOnly {
+			klog.Infof("Orphaned %s: %s/%s, driver service %s is gone", kind, namespace, name, driver)
+			return false
+		}
+		if deletions >= c.sweeper.MaxDeletions {
+			klog.Warningf("Orphaned %s: %s/%s not deleted, reached the limit of %d deletions per sweep",
+				kind, namespace, name, c.sweeper.MaxDeletions)
+			return false
+		}
+		deletions++
+		klog.Infof("Orphaned %s: %s/%s, driver service %s is gone, deleting it", kind, namespace, name, driver)
+		return true
+	}
+
+	services, err := c.servicesLister.List(managedSelector())
+	if err != nil {
+		klog.Errorf("List managed services failed: %s", err.Error())
+		return
+	}
+	for _, svc := range services {
+		if !sweep("service", svc.Namespace, svc.Name, svc.Labels[driverServiceLabel]) {
+			continue
+		}
+		err := c.deleteService(svc.Namespace, svc.Name)
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Delete service: %s/%s failed: %s", svc.Namespace, svc.Name, err.Error())
+			continue
+		}
+		orphansDeletedTotal.inc("service")
+	}
+
+	routes, err := c.ingressRoutesLister.List(managedSelector())
+	if err != nil {
+		klog.Errorf("List managed ingress routes failed: %s", err.Error())
+		return
+	}
+	for _, route := range routes {
+		if route.Annotations[historyAppIDAnnotation] != "" {
+			continue
+		}
+		if !sweep("ingressroute", route.Namespace, route.Name, route.Labels[driverServiceLabel]) {
+			continue
+		}
+		err := c.deleteIngressRoute(route.Namespace, route.Name)
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Delete ingress route: %s/%s failed: %s", route.Namespace, route.Name, err.Error())
+			continue
+		}
+		orphansDeletedTotal.inc("ingressroute")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+// newSweeperFixture returns the objects of a live driver, the managed objects of two drivers
+// that are gone, and objects the sweeper must leave alone.
+func newSweeperFixture(t *testing.T) *fixture {
+	f := newFixture(t)
+	live := newSparkDriverService("live-driver-svc")
+	liveUIService := NewSparkUIService(live, ExposureOptions{})
+	f.svcsLister = append(f.svcsLister, live, liveUIService)
+	f.irsLister = append(f.irsLister, NewSparkUIIngressRoute(liveUIService, live.Name+hostSuffixTest, live,
+		testExposureOptions))
+	for _, name := range []string{"gone1-driver-svc", "gone2-driver-svc"} {
+		gone := newSparkDriverService(name)
+		uiService := NewSparkUIService(gone, ExposureOptions{})
+		f.svcsLister = append(f.svcsLister, uiService)
+		f.irsLister = append(f.irsLister, NewSparkUIIngressRoute(uiService, gone.Name+hostSuffixTest, gone,
+			testExposureOptions))
+	}
+	// an unmanaged ui service, the shared history redirect service and a history redirect route.
+	unmanaged := NewSparkUIService(newSparkDriverService("legacy-driver-svc"), ExposureOptions{})
+	unmanaged.Labels = nil
+	f.svcsLister = append(f.svcsLister, unmanaged, &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:      historyRedirectServiceName,
+		Namespace: metav1.NamespaceDefault,
+		Labels:    map[string]string{managedByLabel: managedByValue},
+	}})
+	f.irsLister = append(f.irsLister, newHistoryRoute("finished-driver-svc",
+		time.Now().Add(time.Hour).UTC().Format(time.RFC3339)))
+	return f
+}
+
+func TestSweepOrphans(t *testing.T) {
+	tests := []struct {
+		name                  string
+		options               SweeperOptions
+		serviceDeletions      int
+		ingressRouteDeletions int
+	}{
+		{name: "deletes orphans", options: SweeperOptions{Interval: time.Minute, MaxDeletions: 10},
+			serviceDeletions: 2, ingressRouteDeletions: 2},
+		{name: "stops at the limit", options: SweeperOptions{Interval: time.Minute, MaxDeletions: 3},
+			serviceDeletions: 2, ingressRouteDeletions: 1},
+		{name: "report only", options: SweeperOptions{Interval: time.Minute, MaxDeletions: 10, ReportOnly: true}},
+	}
+	for _, test := range tests {
+		f := newSweeperFixture(t)
+		c, _, _ := f.newController()
+		c.sweeper = test.options
+
+		c.sweepOrphans()
+
+		svcsactions := filterInformerActions(f.kubeclient.Actions())
+		irsactions := filterInformerActions(f.contourclient.Actions())
+		if len(svcsactions) != test.serviceDeletions || len(irsactions) != test.ingressRouteDeletions {
+			t.Errorf("%s: expected %d service and %d ingress route deletions, got %+v and %+v", test.name,
+				test.serviceDeletions, test.ingressRouteDeletions, svcsactions, irsactions)
+			continue
+		}
+		for _, action := range append(svcsactions, irsactions...) {
+			if action.GetVerb() != "delete" {
+				t.Errorf("%s: unexpected %s %s", test.name, action.GetVerb(), action.GetResource().Resource)
+				continue
+			}
+			name := action.(clientgotesting.DeleteAction).GetName()
+			if name != "gone1-ui-svc" && name != "gone2-ui-svc" &&
+				name != c.getSparkUIIngressRouteName("gone1-ui-svc") && name != c.getSparkUIIngressRouteName("gone2-ui-svc") {
+				t.Errorf("%s: unexpected deletion of %s", test.name, name)
+			}
+		}
+		// the orphans are counted whether they are deleted or not.
+		orphansFound.lock.Lock()
+		services := orphansFound.values[orphansFound.key([]string{"service"})]
+		routes := orphansFound.values[orphansFound.key([]string{"ingressroute"})]
+		orphansFound.lock.Unlock()
+		if services != 2 || routes != 2 {
+			t.Errorf("%s: expected 2 orphaned services and routes, got %v and %v", test.name, services, routes)
+		}
+	}
+}